@@ -2,15 +2,23 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"math"
 )
 
 var label = 0
 var funcName string
 
+// depth is the number of 8-byte values currently pushed by gen.
+// It is used to keep the stack 16-byte aligned at function calls.
+var depth = 0
+
 var argRegisters64 = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+var argRegisters32 = []string{"edi", "esi", "edx", "ecx", "r8d", "r9d"}
+var argRegisters16 = []string{"di", "si", "dx", "cx", "r8w", "r9w"}
 var argRegisters8 = []string{"dil", "sil", "dl", "cl", "r8b", "r9b"}
 
+const maxFloatArgs = 8
+
 func codegen(prog *program) {
 	fmt.Printf(".intel_syntax noprefix\n")
 	emitData()
@@ -19,6 +27,9 @@ func codegen(prog *program) {
 
 func emitData() {
 	for _, gv := range globals {
+		if gv.ty.kind == typeKindFunc {
+			continue
+		}
 		fmt.Printf("	.data\n")
 		fmt.Printf("	.globl %s\n", gv.name)
 		fmt.Printf("%s:\n", gv.name)
@@ -35,6 +46,7 @@ func emitData() {
 func emitText(funcs []*function) {
 	for _, f := range funcs {
 		funcName = f.name
+		depth = 0
 		fmt.Printf(`	.globl %[1]s
 	.text
 %[1]s:
@@ -43,11 +55,31 @@ func emitText(funcs []*function) {
 	sub rsp, %[2]d
 `, funcName, f.stackSize)
 
-		for i, p := range f.params {
-			if p.size == 1 {
-				fmt.Printf("	mov [rbp-%d], %s\n", f.getLocal(p.name).offset, argRegisters8[i])
-			} else {
-				fmt.Printf("	mov [rbp-%d], %s\n", f.getLocal(p.name).offset, argRegisters64[i])
+		gp, fp := 0, 0
+		for _, p := range f.params {
+			if (p.isFlonum() && fp == maxFloatArgs) || (!p.isFlonum() && gp == len(argRegisters64)) {
+				errorTok(p.tok, "too many parameters")
+			}
+			offset := f.getLocal(p.name).offset
+			switch {
+			case p.kind == typeKindFloat:
+				fmt.Printf("	movss [rbp-%d], xmm%d\n", offset, fp)
+				fp++
+			case p.kind == typeKindDouble:
+				fmt.Printf("	movsd [rbp-%d], xmm%d\n", offset, fp)
+				fp++
+			case p.size == 1:
+				fmt.Printf("	mov [rbp-%d], %s\n", offset, argRegisters8[gp])
+				gp++
+			case p.size == 2:
+				fmt.Printf("	mov [rbp-%d], %s\n", offset, argRegisters16[gp])
+				gp++
+			case p.size == 4:
+				fmt.Printf("	mov [rbp-%d], %s\n", offset, argRegisters32[gp])
+				gp++
+			default:
+				fmt.Printf("	mov [rbp-%d], %s\n", offset, argRegisters64[gp])
+				gp++
 			}
 		}
 
@@ -61,15 +93,84 @@ func emitText(funcs []*function) {
 	}
 }
 
+func push() {
+	fmt.Printf("	push rax\n")
+	depth++
+}
+
+func pop(reg string) {
+	fmt.Printf("	pop %s\n", reg)
+	depth--
+}
+
+func pushf() {
+	fmt.Printf("	sub rsp, 8\n")
+	fmt.Printf("	movsd [rsp], xmm0\n")
+	depth++
+}
+
+func popf(reg int) {
+	fmt.Printf("	movsd xmm%d, [rsp]\n", reg)
+	fmt.Printf("	add rsp, 8\n")
+	depth--
+}
+
+// cmpZero pops a value of type ty and compares it with zero.
+func cmpZero(ty *typ) {
+	switch ty.kind {
+	case typeKindFloat:
+		popf(0)
+		fmt.Printf("	xorps xmm1, xmm1\n")
+		fmt.Printf("	ucomiss xmm0, xmm1\n")
+	case typeKindDouble:
+		popf(0)
+		fmt.Printf("	xorpd xmm1, xmm1\n")
+		fmt.Printf("	ucomisd xmm0, xmm1\n")
+	default:
+		pop("rax")
+		fmt.Printf("	cmp rax, 0\n")
+	}
+}
+
+// branchZero pops a condition of type ty and jumps to target if it is zero.
+// A NaN compares unordered with zero, which sets ZF, but it is nonzero.
+func branchZero(ty *typ, target string) {
+	cmpZero(ty)
+	if ty.isFlonum() {
+		fmt.Printf("	setne al\n")
+		fmt.Printf("	setp dl\n")
+		fmt.Printf("	or al, dl\n")
+		fmt.Printf("	cmp al, 0\n")
+	}
+	fmt.Printf("	je %s\n", target)
+}
+
 func gen(n interface{}) {
 	switch n := n.(type) {
 	case *returnStmtNode:
+		if n.child == nil {
+			fmt.Printf("	jmp .Lreturn.%s\n", funcName)
+			return
+		}
 		gen(n.child)
-		fmt.Printf("	pop rax\n")
+		if n.child.getType().isFlonum() {
+			popf(0)
+		} else {
+			pop("rax")
+		}
 		fmt.Printf("	jmp .Lreturn.%s\n", funcName)
 		return
 	case *intLit:
-		fmt.Printf("	push %d\n", n.val)
+		fmt.Printf("	mov rax, %d\n", n.val)
+		push()
+		return
+	case *floatLit:
+		if n.ty.kind == typeKindFloat {
+			fmt.Printf("	mov rax, %d # %g\n", math.Float32bits(float32(n.val)), n.val)
+		} else {
+			fmt.Printf("	mov rax, %d # %g\n", math.Float64bits(n.val), n.val)
+		}
+		push()
 		return
 	case *obj:
 		genAddr(n)
@@ -84,42 +185,47 @@ func gen(n interface{}) {
 		gen(n.rhs)
 		store(n.getType())
 		return
+	case *castNode:
+		gen(n.child)
+		cast(n.child.getType(), n.getType())
+		return
 	case *ifStmtNode:
+		c := label
+		label++
 		gen(n.cond)
-		fmt.Printf("	pop rax\n")
-		fmt.Printf("	cmp rax, 0\n")
 
 		if n.els != nil {
-			fmt.Printf("	je .Lelse%d\n", label)
+			branchZero(n.cond.getType(), fmt.Sprintf(".Lelse%d", c))
 			gen(n.then)
-			fmt.Printf("	jmp .Lend%d\n", label)
-			fmt.Printf(".Lelse%d:\n", label)
+			fmt.Printf("	jmp .Lend%d\n", c)
+			fmt.Printf(".Lelse%d:\n", c)
 			gen(n.els)
-			fmt.Printf(".Lend%d:\n", label)
+			fmt.Printf(".Lend%d:\n", c)
 		} else {
-			fmt.Printf("	je .Lend%d\n", label)
+			branchZero(n.cond.getType(), fmt.Sprintf(".Lend%d", c))
 			gen(n.then)
-			fmt.Printf(".Lend%d:\n", label)
+			fmt.Printf(".Lend%d:\n", c)
 		}
-		label++
 		return
 	case *forStmtNode:
+		c := label
+		label++
 		if n.ini != nil {
 			gen(n.ini)
+			pop("rax")
 		}
-		fmt.Printf(".Lbegin%d:\n", label)
+		fmt.Printf(".Lbegin%d:\n", c)
 		if n.cond != nil {
 			gen(n.cond)
-			fmt.Printf("	pop rax\n")
-			fmt.Printf("	cmp rax, 0\n")
-			fmt.Printf("	je .Lend%d\n", label)
+			branchZero(n.cond.getType(), fmt.Sprintf(".Lend%d", c))
 		}
 		gen(n.then)
 		if n.step != nil {
 			gen(n.step)
+			pop("rax")
 		}
-		fmt.Printf("	jmp .Lbegin%d\n", label)
-		fmt.Printf(".Lend%d:\n", label)
+		fmt.Printf("	jmp .Lbegin%d\n", c)
+		fmt.Printf(".Lend%d:\n", c)
 		return
 	case *blockStmtNode:
 		for _, s := range n.code {
@@ -128,19 +234,64 @@ func gen(n interface{}) {
 		return
 	case *exprStmtNode:
 		gen(n.child)
+		pop("rax")
 		return
 	case *funcCallNode:
 
+		if n.fn != nil {
+			gen(n.fn)
+		}
 		for _, arg := range n.args {
 			gen(arg)
 		}
 
+		gp, fp := 0, 0
+		for _, arg := range n.args {
+			if arg.getType().isFlonum() {
+				fp++
+			} else {
+				gp++
+			}
+		}
+		if gp > len(argRegisters64) || fp > maxFloatArgs {
+			errorTok(n.tok, "too many arguments")
+		}
+
+		numFloatArgs := fp
 		for i := len(n.args) - 1; i >= 0; i-- {
-			fmt.Printf("	pop %s\n", argRegisters64[i])
+			if n.args[i].getType().isFlonum() {
+				fp--
+				popf(fp)
+			} else {
+				gp--
+				pop(argRegisters64[gp])
+			}
+		}
+
+		// An indirect call goes through r10, which is not an argument register.
+		callee := n.name
+		if n.fn != nil {
+			pop("r10")
+			callee = "r10"
 		}
 
-		fmt.Printf("	call %s\n", n.name)
-		fmt.Printf("	push rax\n")
+		// al holds the number of vector registers used, for variadic functions.
+		fmt.Printf("	mov rax, %d\n", numFloatArgs)
+		if depth%2 == 1 {
+			fmt.Printf("	sub rsp, 8\n")
+			fmt.Printf("	call %s\n", callee)
+			fmt.Printf("	add rsp, 8\n")
+		} else {
+			fmt.Printf("	call %s\n", callee)
+		}
+
+		if n.ty.isFlonum() {
+			pushf()
+			return
+		}
+		// The upper bits of a small integer return value are undefined.
+		extend(n.ty)
+		push()
 		return
 	case *addrNode:
 		genAddr(n.child)
@@ -160,8 +311,17 @@ func gen(n interface{}) {
 		gen(b.rhs)
 	}
 
-	fmt.Printf("	pop rdi\n")
-	fmt.Printf("	pop rax\n")
+	if b.lhs.getType().isFlonum() {
+		genFloatBinary(b)
+		return
+	}
+
+	pop("rdi")
+	pop("rax")
+
+	// Pointers are compared as unsigned integers.
+	ty := b.lhs.getType()
+	unsigned := ty.isUnsigned || ty.hasBase()
 
 	switch b.op {
 	case "+":
@@ -170,16 +330,42 @@ func gen(n interface{}) {
 		fmt.Printf("	sub rax, rdi\n")
 	case "*":
 		fmt.Printf("	imul rax, rdi\n")
-	case "/":
-		fmt.Printf("	cqo\n")
-		fmt.Printf("	idiv rdi\n")
+	case "/", "%":
+		if unsigned {
+			fmt.Printf("	xor edx, edx\n")
+			fmt.Printf("	div rdi\n")
+		} else {
+			fmt.Printf("	cqo\n")
+			fmt.Printf("	idiv rdi\n")
+		}
+		if b.op == "%" {
+			fmt.Printf("	mov rax, rdx\n")
+		}
+	case "<<":
+		fmt.Printf("	mov rcx, rdi\n")
+		fmt.Printf("	shl rax, cl\n")
+	case ">>":
+		fmt.Printf("	mov rcx, rdi\n")
+		if unsigned {
+			fmt.Printf("	shr rax, cl\n")
+		} else {
+			fmt.Printf("	sar rax, cl\n")
+		}
 	case "<":
 		fmt.Printf("	cmp rax, rdi\n")
-		fmt.Printf("	setl al\n")
+		if unsigned {
+			fmt.Printf("	setb al\n")
+		} else {
+			fmt.Printf("	setl al\n")
+		}
 		fmt.Printf("	movzb rax, al\n")
 	case "<=":
 		fmt.Printf("	cmp rax, rdi\n")
-		fmt.Printf("	setle al\n")
+		if unsigned {
+			fmt.Printf("	setbe al\n")
+		} else {
+			fmt.Printf("	setle al\n")
+		}
 		fmt.Printf("	movzb rax, al\n")
 	case "==":
 		fmt.Printf("	cmp rax, rdi\n")
@@ -191,7 +377,179 @@ func gen(n interface{}) {
 		fmt.Printf("	movzb rax, al\n")
 	}
 
-	fmt.Printf("	push rax\n")
+	// Arithmetic is done in 64 bits, so the result is truncated to its type.
+	extend(b.getType())
+	push()
+}
+
+// genFloatBinary pops two float or double operands into xmm0 (lhs) and
+// xmm1 (rhs) and pushes the result of b.
+func genFloatBinary(b *binaryNode) {
+	popf(1)
+	popf(0)
+
+	sz := "sd"
+	if b.lhs.getType().kind == typeKindFloat {
+		sz = "ss"
+	}
+
+	switch b.op {
+	case "+":
+		fmt.Printf("	add%s xmm0, xmm1\n", sz)
+		pushf()
+		return
+	case "-":
+		fmt.Printf("	sub%s xmm0, xmm1\n", sz)
+		pushf()
+		return
+	case "*":
+		fmt.Printf("	mul%s xmm0, xmm1\n", sz)
+		pushf()
+		return
+	case "/":
+		fmt.Printf("	div%s xmm0, xmm1\n", sz)
+		pushf()
+		return
+	}
+
+	// Comparisons are false if either operand is NaN, except for "!=".
+	switch b.op {
+	case "<":
+		fmt.Printf("	ucomi%s xmm1, xmm0\n", sz)
+		fmt.Printf("	seta al\n")
+	case "<=":
+		fmt.Printf("	ucomi%s xmm1, xmm0\n", sz)
+		fmt.Printf("	setae al\n")
+	case "==":
+		fmt.Printf("	ucomi%s xmm0, xmm1\n", sz)
+		fmt.Printf("	sete al\n")
+		fmt.Printf("	setnp dl\n")
+		fmt.Printf("	and al, dl\n")
+	case "!=":
+		fmt.Printf("	ucomi%s xmm0, xmm1\n", sz)
+		fmt.Printf("	setne al\n")
+		fmt.Printf("	setp dl\n")
+		fmt.Printf("	or al, dl\n")
+	}
+	fmt.Printf("	movzb rax, al\n")
+	push()
+}
+
+// cast converts the value on the top of the stack from type from to type to.
+func cast(from, to *typ) {
+	switch {
+	case to.kind == typeKindBool && from.kind != typeKindBool:
+		// Any nonzero value converts to 1.
+		cmpZero(from)
+		fmt.Printf("	setne al\n")
+		if from.isFlonum() {
+			fmt.Printf("	setp dl\n")
+			fmt.Printf("	or al, dl\n")
+		}
+		fmt.Printf("	movzx eax, al\n")
+		push()
+	case from.isFlonum() && to.isFlonum():
+		if from.kind == to.kind {
+			return
+		}
+		popf(0)
+		if from.kind == typeKindFloat {
+			fmt.Printf("	cvtss2sd xmm0, xmm0\n")
+		} else {
+			fmt.Printf("	cvtsd2ss xmm0, xmm0\n")
+		}
+		pushf()
+	case from.isFlonum():
+		popf(0)
+		sz := "sd"
+		if from.kind == typeKindFloat {
+			sz = "ss"
+		}
+		if to.isUnsigned && to.size == 8 {
+			// cvtts[sd]2si gives a signed result, so values of 2^63 and more
+			// are converted less 2^63, and the top bit is set back.
+			c := label
+			label++
+			if sz == "ss" {
+				fmt.Printf("	mov eax, %d\n", math.Float32bits(1<<63))
+				fmt.Printf("	movd xmm1, eax\n")
+			} else {
+				fmt.Printf("	mov rax, %d\n", math.Float64bits(1<<63))
+				fmt.Printf("	movq xmm1, rax\n")
+			}
+			fmt.Printf("	ucomi%s xmm0, xmm1\n", sz)
+			fmt.Printf("	jae .Lcast%d\n", c)
+			fmt.Printf("	cvtt%s2si rax, xmm0\n", sz)
+			fmt.Printf("	jmp .Lcast.end%d\n", c)
+			fmt.Printf(".Lcast%d:\n", c)
+			fmt.Printf("	sub%s xmm0, xmm1\n", sz)
+			fmt.Printf("	cvtt%s2si rax, xmm0\n", sz)
+			fmt.Printf("	btc rax, 63\n")
+			fmt.Printf(".Lcast.end%d:\n", c)
+		} else {
+			fmt.Printf("	cvtt%s2si rax, xmm0\n", sz)
+		}
+		extend(to)
+		push()
+	case to.isFlonum():
+		pop("rax")
+		sz := "sd"
+		if to.kind == typeKindFloat {
+			sz = "ss"
+		}
+		if from.isUnsigned && from.size == 8 {
+			// cvtsi2s[sd] takes a signed operand, so values with the top bit
+			// set are halved (keeping the lowest bit for rounding) and doubled back.
+			c := label
+			label++
+			fmt.Printf("	pxor xmm0, xmm0\n")
+			fmt.Printf("	test rax, rax\n")
+			fmt.Printf("	js .Lcast%d\n", c)
+			fmt.Printf("	cvtsi2%s xmm0, rax\n", sz)
+			fmt.Printf("	jmp .Lcast.end%d\n", c)
+			fmt.Printf(".Lcast%d:\n", c)
+			fmt.Printf("	mov rdi, rax\n")
+			fmt.Printf("	and eax, 1\n")
+			fmt.Printf("	shr rdi\n")
+			fmt.Printf("	or rdi, rax\n")
+			fmt.Printf("	cvtsi2%s xmm0, rdi\n", sz)
+			fmt.Printf("	add%s xmm0, xmm0\n", sz)
+			fmt.Printf(".Lcast.end%d:\n", c)
+		} else {
+			fmt.Printf("	pxor xmm0, xmm0\n")
+			fmt.Printf("	cvtsi2%s xmm0, rax\n", sz)
+		}
+		pushf()
+	case to.isInteger() && (to.size < from.size || to.isUnsigned != from.isUnsigned):
+		pop("rax")
+		extend(to)
+		push()
+	}
+}
+
+// extend truncates the integer in rax to the size of ty and then sign- or
+// zero-extends it back to 64 bits, which is how values are kept in registers.
+func extend(ty *typ) {
+	if !ty.isInteger() {
+		return
+	}
+	switch {
+	case ty.kind == typeKindBool:
+		// A _Bool is 0 or 1 in al; the rest of the register is undefined.
+		fmt.Printf("	movzx eax, al\n")
+	case ty.size == 1 && ty.isUnsigned:
+		fmt.Printf("	movzx eax, al\n")
+	case ty.size == 1:
+		fmt.Printf("	movsx rax, al\n")
+	case ty.size == 2 && ty.isUnsigned:
+		fmt.Printf("	movzx eax, ax\n")
+	case ty.size == 2:
+		fmt.Printf("	movsx rax, ax\n")
+	case ty.size == 4 && ty.isUnsigned:
+		fmt.Printf("	mov eax, eax\n")
+	case ty.size == 4:
+		fmt.Printf("	movsxd rax, eax\n")
+	}
 }
 
 func genAddr(n expression) {
@@ -199,45 +557,63 @@ func genAddr(n expression) {
 	case *obj:
 		if n.isLocal {
 			fmt.Printf("	lea rax, [rbp-%d]\n", n.offset)
-			fmt.Printf("	push rax\n")
 		} else {
-			fmt.Printf("	push offset %s\n", n.name)
+			fmt.Printf("	lea rax, %s[rip]\n", n.name)
 		}
+		push()
 	case *derefNode:
 		gen(n.child)
 	case *memberNode:
 		genAddr(n.child)
-		fmt.Printf("	pop rax\n")
+		pop("rax")
 		fmt.Printf("	add rax, %d\n", n.member.offset)
-		fmt.Printf("	push rax\n")
+		push()
 	default:
-		_, _ = fmt.Fprintln(os.Stderr, "Not an identifier")
-		os.Exit(1)
+		errorTok(n.getToken(), "not an lvalue")
 	}
 }
 
 func load(ty *typ) {
-	if ty.kind == typeKindArray {
+	// An array or a function is not loaded; its address is the value.
+	if ty.kind == typeKindArray || ty.kind == typeKindFunc {
 		return
 	}
-	if ty.size == 1 {
-		fmt.Printf("	pop rax\n")
-		fmt.Printf("	movzx rax, byte ptr [rax]\n")
-		fmt.Printf("	push rax\n")
-	} else {
-		fmt.Printf("	pop rax\n")
+	pop("rax")
+
+	// Signed integers are sign-extended; everything else is zero-extended.
+	signed := ty.isInteger() && !ty.isUnsigned && ty.kind != typeKindBool
+	switch {
+	case ty.size == 1 && signed:
+		fmt.Printf("	movsx rax, byte ptr [rax]\n")
+	case ty.size == 1:
+		fmt.Printf("	movzx eax, byte ptr [rax]\n")
+	case ty.size == 2 && signed:
+		fmt.Printf("	movsx rax, word ptr [rax]\n")
+	case ty.size == 2:
+		fmt.Printf("	movzx eax, word ptr [rax]\n")
+	case ty.size == 4 && signed:
+		fmt.Printf("	movsxd rax, dword ptr [rax]\n")
+	case ty.size == 4:
+		fmt.Printf("	mov eax, dword ptr [rax]\n")
+	default:
 		fmt.Printf("	mov rax, [rax]\n")
-		fmt.Printf("	push rax\n")
 	}
+	push()
 }
 
 func store(ty *typ) {
-	fmt.Printf("	pop rdi\n")
-	fmt.Printf("	pop rax\n")
-	if ty.size == 1 {
+	pop("rdi")
+	pop("rax")
+	switch ty.size {
+	case 1:
 		fmt.Printf("	mov [rax], dil\n")
-	} else {
+	case 2:
+		fmt.Printf("	mov [rax], di\n")
+	case 4:
+		fmt.Printf("	mov [rax], edi\n")
+	default:
 		fmt.Printf("	mov [rax], rdi\n")
 	}
-	fmt.Printf("	push rdi\n") // e.g. a=b=3
+	fmt.Printf("	mov rax, rdi\n")
+	push() // e.g. a=b=3
 }
@@ -3,19 +3,106 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 var tokens []*token
 var locals []*obj
 
+var optDollarsInIdent bool
+var optE bool
+var optVoidPointerArith bool
+
 func main() {
-	if len(os.Args) != 2 {
+	initMacros()
+
+	var input string
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// Options taking an argument accept both "-Idir" and "-I dir".
+		if dir, ok := optionArg(arg, "-I", args, &i); ok {
+			includePaths = append(includePaths, dir)
+			continue
+		}
+		if dir, ok := optionArg(arg, "-iquote", args, &i); ok {
+			quoteIncludePaths = append(quoteIncludePaths, dir)
+			continue
+		}
+		if dir, ok := optionArg(arg, "-isystem", args, &i); ok {
+			systemIncludePaths = append(systemIncludePaths, dir)
+			continue
+		}
+
+		// -D and -U take effect in the order they are given.
+		if def, ok := optionArg(arg, "-D", args, &i); ok {
+			define(def)
+			continue
+		}
+		if name, ok := optionArg(arg, "-U", args, &i); ok {
+			undefMacro(name)
+			continue
+		}
+
+		switch {
+		case arg == "-E":
+			optE = true
+		case arg == "-fdollars-in-identifiers":
+			optDollarsInIdent = true
+		case arg == "-fno-dollars-in-identifiers":
+			optDollarsInIdent = false
+		case arg == "-fvoid-pointer-arith":
+			optVoidPointerArith = true
+		case arg == "-fno-void-pointer-arith":
+			optVoidPointerArith = false
+		case input == "":
+			input = arg
+		default:
+			_, _ = fmt.Fprintf(os.Stderr, "unexpected argument: %s\n", arg)
+			os.Exit(1)
+		}
+	}
+	if input == "" {
 		_, _ = fmt.Fprintf(os.Stderr, "the number of arguments is insufficient\n")
 		os.Exit(1)
 	}
 
-	tokenizeFile(os.Args[1])
+	toks := preprocess(tokenizeFile(input))
+	if optE {
+		printTokens(os.Stdout, toks)
+		return
+	}
+	convertTokens(toks)
+
+	tokens = toks
 	prog := parse()
 
 	codegen(prog)
 }
+
+// define handles "-D name" and "-D name=value". The value defaults to 1.
+func define(def string) {
+	if eq := strings.IndexByte(def, '='); eq >= 0 {
+		defineMacro(def[:eq], def[eq+1:])
+		return
+	}
+	defineMacro(def, "1")
+}
+
+// optionArg reports whether arg is the option name, and returns its argument,
+// which is either joined to arg or the next element of args.
+func optionArg(arg, name string, args []string, i *int) (string, bool) {
+	if !strings.HasPrefix(arg, name) {
+		return "", false
+	}
+	if arg != name {
+		return arg[len(name):], true
+	}
+	if *i+1 >= len(args) {
+		_, _ = fmt.Fprintf(os.Stderr, "missing argument to %s\n", name)
+		os.Exit(1)
+	}
+	*i++
+	return args[*i], true
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"fmt"
-	"os"
 )
 
 func consume(c string) bool {
-	if len(tokens) > 0 && tokens[0].val == c {
+	if tokens[0].kind != tokenKindEOF && tokens[0].val == c {
 		advance()
 		return true
 	}
@@ -14,7 +13,7 @@ func consume(c string) bool {
 }
 
 func equalToken(kind tokenKind) bool {
-	return len(tokens) > 0 && tokens[0].kind == kind
+	return tokens[0].kind == kind
 }
 
 func consumeToken(kind tokenKind) *token {
@@ -28,13 +27,14 @@ func consumeToken(kind tokenKind) *token {
 
 func expect(c string) {
 	if !consume(c) {
-		_, _ = fmt.Fprintln(os.Stderr, "Unexpected token:", tokens[0].val, "want:", c)
-		os.Exit(1)
+		errorTok(tokens[0], "expected '%s'", c)
 	}
 }
 
 func advance() {
-	tokens = tokens[1:]
+	if tokens[0].kind != tokenKindEOF {
+		tokens = tokens[1:]
+	}
 }
 
 type program struct {
@@ -45,6 +45,7 @@ var globals = make(map[string]*obj)
 
 type function struct {
 	name      string
+	returnTy  *typ
 	params    []*typ
 	body      statement
 	locals    []*obj
@@ -53,10 +54,18 @@ type function struct {
 
 type obj struct {
 	ty   *typ
+	tok  *token
 	name string
 
 	isLocal bool
 
+	// enumerator, which is an integer constant rather than a variable
+	isEnum  bool
+	enumVal int
+
+	// typedef name, which stands for ty
+	isTypedef bool
+
 	// local variable
 	offset int
 
@@ -68,6 +77,7 @@ type expression interface {
 	isExpr()
 	getType() *typ
 	setType(ty *typ)
+	getToken() *token
 }
 
 type statement interface {
@@ -76,6 +86,7 @@ type statement interface {
 
 type binaryNode struct {
 	ty  *typ
+	tok *token
 	op  string
 	lhs expression
 	rhs expression
@@ -85,6 +96,7 @@ type assignNode binaryNode
 
 type unaryNode struct {
 	ty    *typ
+	tok   *token
 	child expression
 }
 
@@ -92,15 +104,25 @@ type exprStmtNode unaryNode
 type returnStmtNode unaryNode
 type addrNode unaryNode
 type derefNode unaryNode
+type castNode unaryNode
 
 type intLit struct {
 	ty  *typ
+	tok *token
 	val int
 }
 
+type floatLit struct {
+	ty  *typ
+	tok *token
+	val float64
+}
+
 type funcCallNode struct {
 	ty   *typ
-	name string
+	tok  *token
+	name string     // callee of a direct call
+	fn   expression // callee of an indirect call, a function or a pointer to one
 	args []expression
 }
 
@@ -115,6 +137,8 @@ func (n *memberNode) getType() *typ { return n.ty }
 
 func (n *memberNode) setType(ty *typ) { n.ty = ty }
 
+func (n *memberNode) getToken() *token { return n.tok }
+
 func (*binaryNode) isExpr()     {}
 func (*assignNode) isExpr()     {}
 func (*unaryNode) isExpr()      {}
@@ -122,7 +146,9 @@ func (*exprStmtNode) isStmt()   {}
 func (*returnStmtNode) isStmt() {}
 func (*addrNode) isExpr()       {}
 func (*derefNode) isExpr()      {}
+func (*castNode) isExpr()       {}
 func (*intLit) isExpr()         {}
+func (*floatLit) isExpr()       {}
 func (*obj) isExpr()            {}
 func (*funcCallNode) isExpr()   {}
 
@@ -133,7 +159,9 @@ func (n *exprStmtNode) getType() *typ   { return n.ty }
 func (n *returnStmtNode) getType() *typ { return n.ty }
 func (n *addrNode) getType() *typ       { return n.ty }
 func (n *derefNode) getType() *typ      { return n.ty }
+func (n *castNode) getType() *typ       { return n.ty }
 func (n *intLit) getType() *typ         { return n.ty }
+func (n *floatLit) getType() *typ       { return n.ty }
 func (n *obj) getType() *typ            { return n.ty }
 func (n *funcCallNode) getType() *typ   { return n.ty }
 
@@ -144,17 +172,32 @@ func (n *exprStmtNode) setType(ty *typ)   { n.ty = ty }
 func (n *returnStmtNode) setType(ty *typ) { n.ty = ty }
 func (n *addrNode) setType(ty *typ)       { n.ty = ty }
 func (n *derefNode) setType(ty *typ)      { n.ty = ty }
+func (n *castNode) setType(ty *typ)       { n.ty = ty }
 func (n *intLit) setType(ty *typ)         { n.ty = ty }
+func (n *floatLit) setType(ty *typ)       { n.ty = ty }
 func (n *obj) setType(ty *typ)            { n.ty = ty }
 func (n *funcCallNode) setType(ty *typ)   { n.ty = ty }
 
+func (n *binaryNode) getToken() *token   { return n.tok }
+func (n *assignNode) getToken() *token   { return n.tok }
+func (n *unaryNode) getToken() *token    { return n.tok }
+func (n *addrNode) getToken() *token     { return n.tok }
+func (n *derefNode) getToken() *token    { return n.tok }
+func (n *castNode) getToken() *token     { return n.tok }
+func (n *intLit) getToken() *token       { return n.tok }
+func (n *floatLit) getToken() *token     { return n.tok }
+func (n *obj) getToken() *token          { return n.tok }
+func (n *funcCallNode) getToken() *token { return n.tok }
+
 type ifStmtNode struct {
+	tok  *token
 	cond expression
 	then statement
 	els  statement
 }
 
 type forStmtNode struct {
+	tok  *token
 	ini  expression
 	cond expression
 	step expression
@@ -162,6 +205,7 @@ type forStmtNode struct {
 }
 
 type blockStmtNode struct {
+	tok  *token
 	code []statement
 }
 
@@ -194,11 +238,19 @@ func pushTagScope(t *typ) {
 	sc.tags = append(sc.tags, t)
 }
 
+// findCurrentTag looks up a tag in the innermost scope only.
+func findCurrentTag(name string) *typ {
+	for _, t := range scopes[len(scopes)-1].tags {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
 func findTag(name string) *typ {
 	for i := len(scopes) - 1; i >= 0; i-- {
-		sc := scopes[i]
-		for vi := range sc.vars {
-			t := sc.tags[vi]
+		for _, t := range scopes[i].tags {
 			if t.name == name {
 				return t
 			}
@@ -218,16 +270,24 @@ func (f *function) getLocal(name string) *obj {
 	return nil
 }
 
-func findLocalInScope(name string) *obj {
+// findVarScope returns the innermost variable, enumerator or typedef name
+// in scope, or nil.
+func findVarScope(name string) *obj {
 	for i := len(scopes) - 1; i >= 0; i-- {
 		sc := scopes[i]
-		for vi := range sc.vars {
-			lv := sc.vars[vi]
-			if lv.name == name {
-				return lv
+		for vi := len(sc.vars) - 1; vi >= 0; vi-- {
+			if v := sc.vars[vi]; v.name == name {
+				return v
 			}
 		}
 	}
+	return nil
+}
+
+func findLocalInScope(name string) *obj {
+	if lv := findVarScope(name); lv != nil {
+		return lv
+	}
 
 	if gv, ok := globals[name]; ok {
 		return gv
@@ -241,6 +301,7 @@ func newNodeLocal(ty *typ) *obj {
 	lv := &obj{
 		name:    ty.name,
 		ty:      ty,
+		tok:     ty.tok,
 		isLocal: true,
 	}
 
@@ -256,12 +317,21 @@ func newGlobalVariable(ty *typ) *obj {
 	}
 	gv := &obj{
 		ty:   ty,
+		tok:  ty.tok,
 		name: ty.name,
 	}
 	globals[ty.name] = gv
 	return gv
 }
 
+func newCast(n expression, ty *typ) expression {
+	addType(n)
+	if n.getType().kind == typeKindVoid && ty.kind != typeKindVoid {
+		errorTok(n.getToken(), "void value not ignored as it ought to be")
+	}
+	return &castNode{tok: n.getToken(), ty: ty, child: n}
+}
+
 var uniqueID = 0
 
 func newUniqueName() string {
@@ -270,53 +340,144 @@ func newUniqueName() string {
 	return s
 }
 
-func newStringLiteral(s string) *obj {
-	ty := newLiteralType("char")
-	ty = arrayOf(ty, len(s))
+// newStringLiteral makes a string literal of adjacent string literal tokens,
+// which are concatenated. An unprefixed literal takes the prefix of the others.
+func newStringLiteral(toks []*token) *obj {
+	prefix := ""
+	var chars []strChar
+	for _, t := range toks {
+		if p := stringPrefix(t); p != "" {
+			if prefix != "" && p != prefix {
+				errorTok(t, "unsupported non-standard concatenation of string literals")
+			}
+			prefix = p
+		}
+		chars = append(chars, t.chars...)
+	}
+
+	elem := stringElemType(prefix)
+	data := encodeString(chars, elem)
+	ty := arrayOf(elem, len(data)/elem.size)
 	ty.name = newUniqueName()
+	ty.tok = toks[0]
 	gv := newGlobalVariable(ty)
-	gv.initData = []byte(s)
+	gv.initData = data
 	return gv
 }
 
 // program = decl*
-// decl = declspec declarator ("{" funcDecl | varDecl)
+// decl = declspec (";" | typedef | declarator ("{" funcDecl | varDecl))
 func parse() *program {
 	prog := &program{
 		funcs: []*function{},
 	}
-	for len(tokens) > 0 {
-		ty := declSpec()
-		ty = declarator(ty)
-		if consume("{") {
+
+	// The file scope holds tags and enumerators declared outside functions.
+	enterScope()
+
+	for !equalToken(tokenKindEOF) {
+		attr := &varAttr{}
+		baseTy := declSpec(attr)
+		if attr.isTypedef {
+			parseTypedef(baseTy)
+			continue
+		}
+
+		// A declaration of a tag or enumerators only.
+		if consume(";") {
+			continue
+		}
+
+		ty := declarator(baseTy)
+		if ty.kind == typeKindFunc && consume("{") {
 			prog.funcs = append(prog.funcs, funcDecl(ty))
 			continue
 		}
-		varDecl(ty)
+		varDecl(baseTy, ty)
 	}
 	return prog
 }
 
+// typedef = declarator ("," declarator)* ";"
+func parseTypedef(baseTy *typ) {
+	for i := 0; !consume(";"); i++ {
+		if i > 0 {
+			expect(",")
+		}
+		ty := declarator(baseTy)
+		pushScope(&obj{name: ty.name, tok: ty.tok, ty: ty, isTypedef: true})
+	}
+}
+
+// checkIncompleteVariable rejects objects of type void, of an array type
+// without a length, and of a struct or union type whose members are not
+// defined yet.
+func checkIncompleteVariable(ty *typ) {
+	if ty.kind == typeKindVoid {
+		errorTok(ty.tok, "variable has incomplete type 'void'")
+	}
+	if ty.kind == typeKindArray && ty.length < 0 {
+		errorTok(ty.tok, "array size missing in '%s'", ty.name)
+	}
+	if ty.isIncomplete {
+		errorTok(ty.tok, "variable has incomplete type '%s'", tagName(ty))
+	}
+}
+
+// tagName returns how a struct or union type is written, as in "struct node".
+func tagName(ty *typ) string {
+	kw := "struct"
+	if ty.kind == typeKindUnion {
+		kw = "union"
+	}
+	if ty.origin != nil {
+		ty = ty.origin
+	}
+	return kw + " " + ty.name
+}
+
 // varDecl = ("," declarator)* ";"
-func varDecl(ty *typ) {
+// ty is the first declarator, which has been read with baseTy.
+func varDecl(baseTy, ty *typ) {
+	ty = completeGlobalArray(ty)
+	checkIncompleteVariable(ty)
 	_ = newGlobalVariable(ty)
 	for consume(",") {
-		ty = declarator(ty)
+		ty = completeGlobalArray(declarator(baseTy))
+		checkIncompleteVariable(ty)
 		_ = newGlobalVariable(ty)
 	}
 	expect(";")
 }
 
+// completeGlobalArray gives a file-scope array declared as "int a[];" one
+// element, as gcc does, since nothing else completes it.
+func completeGlobalArray(ty *typ) *typ {
+	if ty.kind != typeKindArray || ty.length >= 0 {
+		return ty
+	}
+	warnTok(ty.tok, "array '%s' assumed to have one element", ty.name)
+	name, tok := ty.name, ty.tok
+	ty = arrayOf(ty.base, 1)
+	ty.name, ty.tok = name, tok
+	return ty
+}
+
+var currentFn *function
+
 // funcDecl = compoundStmt
 func funcDecl(ty *typ) *function {
 
 	locals = []*obj{}
-	scopes = []*scope{}
+
+	_ = newGlobalVariable(ty)
 
 	f := &function{
-		name:   ty.name,
-		params: ty.params,
+		name:     ty.name,
+		returnTy: ty.returnTy,
+		params:   ty.params,
 	}
+	currentFn = f
 
 	enterScope()
 
@@ -335,50 +496,257 @@ func funcDecl(ty *typ) *function {
 	return f
 }
 
-// declspec = "int" | "char" | struct-decl
-func declSpec() *typ {
-	tok := consumeToken(tokenKindType)
-	if tok == nil {
-		_, _ = fmt.Fprintln(os.Stderr, "Expect an type:", tokens[0].val)
-		os.Exit(1)
+// varAttr holds the storage class specifiers of a declaration.
+type varAttr struct {
+	isTypedef bool
+}
+
+// isTypename reports whether tok starts a declaration.
+func isTypename(tok *token) bool {
+	return tok.kind == tokenKindType || findTypedef(tok) != nil
+}
+
+// findTypedef returns the type that tok names if it is a typedef name in
+// the current scope, or nil.
+func findTypedef(tok *token) *typ {
+	if tok.kind != tokenKindIdent {
+		return nil
+	}
+	if v := findVarScope(tok.val); v != nil && v.isTypedef {
+		return v.ty
 	}
+	return nil
+}
 
-	if tok.val == "struct" {
-		return structDecl()
+// declspec = ("typedef" | "void" | "_Bool" | "char" | "short" | "int" | "long" | "signed" | "unsigned" | "float" | "double" | struct-decl | union-decl | enum-spec | typedef-name)+
+//
+// declSpec reads declaration specifiers. attr receives the storage class
+// specifiers, and is nil where they are not allowed.
+func declSpec(attr *varAttr) *typ {
+	// Type names are counted so that they may be written in any order,
+	// e.g. "long int" and "int long".
+	const (
+		specChar     = 1 << 0
+		specShort    = 1 << 2
+		specInt      = 1 << 4
+		specLong     = 1 << 6
+		specFloat    = 1 << 8
+		specDouble   = 1 << 10
+		specSigned   = 1 << 12
+		specUnsigned = 1 << 14
+		specVoid     = 1 << 16
+		specBool     = 1 << 18
+		specOther    = 1 << 20 // struct, union, enum and typedef names
+	)
+	counts := map[string]int{
+		"void": specVoid, "_Bool": specBool, "char": specChar, "short": specShort, "int": specInt, "long": specLong,
+		"float": specFloat, "double": specDouble, "signed": specSigned, "unsigned": specUnsigned,
 	}
 
-	return newLiteralType(tok.val)
+	var ty *typ
+	counter := 0
+	for isTypename(tokens[0]) {
+		tok := tokens[0]
+
+		if tok.val == "typedef" {
+			if attr == nil {
+				errorTok(tok, "storage class specifier is not allowed in this context")
+			}
+			attr.isTypedef = true
+			advance()
+			continue
+		}
+
+		// A struct, union, enum or typedef name cannot be combined with other
+		// type names. After one, a typedef name is the declarator, as in
+		// "typedef int T; T T;".
+		if tok.val == "struct" || tok.val == "union" || tok.val == "enum" || tok.kind == tokenKindIdent {
+			if counter > 0 {
+				break
+			}
+			advance()
+			switch tok.val {
+			case "struct":
+				ty = structDecl()
+			case "union":
+				ty = unionDecl()
+			case "enum":
+				ty = enumSpec()
+			default:
+				ty = findTypedef(tok)
+				// A struct named before its definition is completed later.
+				if ty.origin != nil && (ty.kind == typeKindStruct || ty.kind == typeKindUnion) {
+					ty = ty.origin
+				}
+			}
+			counter += specOther
+			continue
+		}
+
+		c := counts[tok.val]
+		advance()
+
+		counter += c
+		switch counter {
+		case specVoid:
+			ty = newLiteralType("void")
+		case specBool:
+			ty = newLiteralType("bool")
+		case specChar, specSigned + specChar:
+			ty = newLiteralType("char")
+		case specUnsigned + specChar:
+			ty = newLiteralType("unsigned char")
+		case specShort, specShort + specInt, specSigned + specShort, specSigned + specShort + specInt:
+			ty = newLiteralType("short")
+		case specUnsigned + specShort, specUnsigned + specShort + specInt:
+			ty = newLiteralType("unsigned short")
+		case specInt, specSigned, specSigned + specInt:
+			ty = newLiteralType("int")
+		case specUnsigned, specUnsigned + specInt:
+			ty = newLiteralType("unsigned int")
+		case specLong, specLong + specInt, specLong + specLong, specLong + specLong + specInt,
+			specSigned + specLong, specSigned + specLong + specInt,
+			specSigned + specLong + specLong, specSigned + specLong + specLong + specInt:
+			ty = newLiteralType("long")
+		case specUnsigned + specLong, specUnsigned + specLong + specInt,
+			specUnsigned + specLong + specLong, specUnsigned + specLong + specLong + specInt:
+			ty = newLiteralType("unsigned long")
+		case specFloat:
+			ty = newLiteralType("float")
+		case specDouble:
+			ty = newLiteralType("double")
+		default:
+			errorTok(tok, "invalid type")
+		}
+	}
+	if ty == nil {
+		errorTok(tokens[0], "expected a type")
+	}
+	return ty
 }
 
-// struct-decl = ident? "{" (declspec declarator ("," declarator)* ";")* "}"
+// enum-spec = ident? "{" enum-list? "}" | ident
+// enum-list = ident ("=" const-expr)? ("," ident ("=" const-expr)?)* ","?
+func enumSpec() *typ {
+	tagTok := consumeToken(tokenKindIdent)
+	if tagTok != nil && tokens[0].val != "{" {
+		ty := findTag(tagTok.val)
+		if ty == nil {
+			errorTok(tagTok, "unknown enum tag")
+		}
+		if ty.kind != typeKindEnum {
+			errorTok(tagTok, "not an enum tag")
+		}
+		return ty
+	}
+
+	expect("{")
+
+	ty := enumType()
+	val := 0
+	for i := 0; !consume("}"); i++ {
+		if i > 0 {
+			expect(",")
+			if consume("}") {
+				break
+			}
+		}
+
+		tok := consumeToken(tokenKindIdent)
+		if tok == nil {
+			errorTok(tokens[0], "expected an identifier")
+		}
+		if consume("=") {
+			val = constExpr()
+		}
+
+		pushScope(&obj{name: tok.val, tok: tok, ty: ty, isEnum: true, enumVal: val})
+		val++
+	}
+
+	if tagTok != nil {
+		ty.name = tagTok.val
+		pushTagScope(ty)
+	}
+	return ty
+}
+
+// struct-decl = struct-union-decl
 func structDecl() *typ {
+	return structUnionDecl("struct")
+}
 
-	// Try to read a struct tag.
+// union-decl = struct-union-decl
+func unionDecl() *typ {
+	return structUnionDecl("union")
+}
+
+// struct-union-decl = ident? "{" (declspec declarator ("," declarator)* ";")* "}"
+//
+// structUnionDecl parses the body shared by struct and union declarations.
+// kw is "struct" or "union"; both share the tag namespace.
+func structUnionDecl(kw string) *typ {
+
+	kind := typeKindStruct
+	if kw == "union" {
+		kind = typeKindUnion
+	}
+
+	// Try to read a tag.
 	var tag string
-	if t := consumeToken(tokenKindIdent); t != nil {
-		tag = t.val
+	tagTok := consumeToken(tokenKindIdent)
+	if tagTok != nil {
+		tag = tagTok.val
 	}
 
 	if tag != "" && tokens[0].val != "{" {
-		ty := findTag(tag)
+		// "struct tag;" declares a new type in the current scope, even if
+		// an outer scope has the same tag.
+		var ty *typ
+		if tokens[0].val == ";" {
+			ty = findCurrentTag(tag)
+		} else {
+			ty = findTag(tag)
+		}
 		if ty == nil {
-			_, _ = fmt.Fprintf(os.Stderr, "Unknown struct tag: %s\n", tag)
-			os.Exit(1)
+			ty = newIncompleteType(kind, tag)
+			pushTagScope(ty)
+			return ty
+		}
+		if ty.kind != kind {
+			errorTok(tagTok, "not a %s tag", kw)
 		}
 		return ty
 	}
 
 	expect("{")
 
+	// The tag is declared before the members so that they can point to it.
+	// A type declared earlier in the same scope is completed in place, so
+	// that pointers to it see the members.
+	var ty *typ
+	if tag != "" {
+		ty = findCurrentTag(tag)
+		if ty == nil {
+			ty = newIncompleteType(kind, tag)
+			pushTagScope(ty)
+		} else if ty.kind != kind {
+			errorTok(tagTok, "not a %s tag", kw)
+		} else if !ty.isIncomplete {
+			errorTok(tagTok, "redefinition of '%s %s'", kw, tag)
+		}
+	}
+
 	var members []*member
 	for !consume("}") {
-		baseTy := declSpec()
+		baseTy := declSpec(nil)
 
 		for i := 0; !consume(";"); i++ {
 			if i > 0 {
 				expect(",")
 			}
 			ty := declarator(baseTy)
+			checkIncompleteVariable(ty)
 			members = append(members, &member{
 				ty:   ty,
 				name: ty.name,
@@ -386,49 +754,130 @@ func structDecl() *typ {
 		}
 	}
 
-	ty := newStructType(members)
-
-	if tag != "" {
-		ty.name = tag
-		pushTagScope(ty)
+	var def *typ
+	if kind == typeKindUnion {
+		def = newUnionType(members)
+	} else {
+		def = newStructType(members)
 	}
 
+	if ty == nil {
+		return def
+	}
+	def.name = tag
+	*ty = *def
 	return ty
 }
 
-// declarator = "*"* ident type-suffix
+// Whether a declarator names what it declares.
+const (
+	nameRequired = iota // a declarator
+	nameNone            // an abstract declarator, as in a type name
+	nameOptional        // a parameter, which may be unnamed
+)
+
+// declarator = "*"* ("(" declarator ")" | ident) type-suffix
 func declarator(baseTy *typ) *typ {
+	return parseDeclarator(baseTy, nameRequired)
+}
+
+// abstract-declarator = "*"* ("(" abstract-declarator ")")? type-suffix
+func abstractDeclarator(baseTy *typ) *typ {
+	return parseDeclarator(baseTy, nameNone)
+}
+
+// type-name = declspec abstract-declarator
+func typeName() *typ {
+	return abstractDeclarator(declSpec(nil))
+}
 
-	ty := new(typ)
-	*ty = *baseTy
+// parseDeclarator reads a declarator, which is abstract or optionally
+// named depending on mode.
+func parseDeclarator(baseTy *typ, mode int) *typ {
 
+	// Pointers refer to baseTy itself, which may be a struct completed later.
+	ty := baseTy
 	for consume("*") {
 		ty = pointerTo(ty)
 	}
 
-	tok := consumeToken(tokenKindIdent)
-	if tok == nil {
-		_, _ = fmt.Fprintln(os.Stderr, "Expect an identifier in declarator:", tokens[0].val)
-		os.Exit(1)
+	// In "int (*fp)(int)", the type suffix applies before the inner
+	// declarator, so the inner one is skipped, the suffix is read, and the
+	// inner one is read again with the resulting type.
+	if isNestedDeclarator(mode) {
+		advance()
+		start := tokens
+		parseDeclarator(newLiteralType("int"), mode)
+		expect(")")
+		ty = typeSuffix(ty)
+		rest := tokens
+		tokens = start
+		ty = parseDeclarator(ty, mode)
+		tokens = rest
+		return ty
 	}
 
-	ty.name = tok.val
+	if ty == baseTy {
+		ty = copyType(ty)
+	}
+	ty.name = ""
+	ty.tok = tokens[0]
+	if mode != nameNone {
+		if tok := consumeToken(tokenKindIdent); tok != nil {
+			ty.name = tok.val
+			ty.tok = tok
+		} else if mode == nameRequired {
+			errorTok(tokens[0], "expected an identifier")
+		}
+	}
 
 	ty = typeSuffix(ty)
 
 	return ty
 }
 
-// type-suffix = "(" func-params | "[" num "]" type-suffix | ε
+// isNestedDeclarator reports whether a "(" starts a nested declarator
+// rather than the parameter list of a function type, as in "int (int)".
+func isNestedDeclarator(mode int) bool {
+	if tokens[0].val != "(" {
+		return false
+	}
+	if mode == nameRequired {
+		return true
+	}
+	next := tokens[1]
+	if next.val == "*" || next.val == "(" {
+		return true
+	}
+	return mode == nameOptional && next.kind == tokenKindIdent && !isTypename(next)
+}
+
+// type-suffix = "(" func-params | "[" const-expr? "]" type-suffix | ε
 func typeSuffix(ty *typ) *typ {
 	if consume("(") {
 		return funcParams(ty)
 	}
 
-	if consume("[") {
-		length := num().val
-		expect("]")
+	if tok := tokens[0]; consume("[") {
+		// "[]" leaves the length unknown, which is -1.
+		length := -1
+		if !consume("]") {
+			length = constExpr()
+			if length < 0 {
+				errorTok(tok, "array size is negative")
+			}
+			expect("]")
+		}
 		ty = typeSuffix(ty)
+		if ty.kind == typeKindVoid {
+			errorTok(tok, "array has incomplete element type 'void'")
+		}
+		if ty.isIncomplete {
+			errorTok(tok, "array has incomplete element type '%s'", tagName(ty))
+		}
+		if ty.kind == typeKindArray && ty.length < 0 {
+			errorTok(tok, "array has incomplete element type")
+		}
 		ty = arrayOf(ty, length)
 		return ty
 	}
@@ -436,20 +885,54 @@ func typeSuffix(ty *typ) *typ {
 	return ty
 }
 
-// func-params = (param ("," param)*)? ")"
-// param = declspec declarator
+// func-params = ("void" | param ("," param)* ("," "...")?)? ")"
+// param = declspec (declarator | abstract-declarator)
 func funcParams(ty *typ) *typ {
+	// "()" is not a prototype; calls are not checked against it.
+	if consume(")") {
+		return funcType(ty, nil, false)
+	}
+
 	var params []*typ
+	isVariadic := false
 	for i := 0; !consume(")"); i++ {
 		if i > 0 {
 			expect(",")
 		}
-		p := declSpec()
-		p = declarator(p)
+		if consume("...") {
+			isVariadic = true
+			expect(")")
+			break
+		}
+
+		// "(void)" declares that there are no parameters.
+		if i == 0 && tokens[0].val == "void" && tokens[1].val == ")" {
+			advance()
+			advance()
+			break
+		}
+
+		p := declSpec(nil)
+		p = parseDeclarator(p, nameOptional)
+
+		// A parameter of array type is adjusted to a pointer to its element,
+		// and one of function type to a pointer to the function.
+		switch p.kind {
+		case typeKindArray:
+			name, tok := p.name, p.tok
+			p = pointerTo(p.base)
+			p.name, p.tok = name, tok
+		case typeKindFunc:
+			name, tok := p.name, p.tok
+			p = pointerTo(p)
+			p.name, p.tok = name, tok
+		}
+		checkIncompleteVariable(p)
 		params = append(params, p)
 	}
-	ty.params = params
-	return ty
+	fn := funcType(ty, params, isVariadic)
+	fn.hasProto = true
+	return fn
 }
 
 // compoundStmt = (declaration | stmt)* "}"
@@ -457,9 +940,9 @@ func compoundStmt() statement {
 
 	enterScope()
 
-	ret := &blockStmtNode{code: []statement{}}
+	ret := &blockStmtNode{tok: tokens[0], code: []statement{}}
 	for !consume("}") {
-		if equalToken(tokenKindType) {
+		if isTypename(tokens[0]) {
 			ret.code = append(ret.code, declaration()...)
 		} else {
 			ret.code = append(ret.code, stmt())
@@ -471,19 +954,35 @@ func compoundStmt() statement {
 	return ret
 }
 
-// declaration = declspec declarator ("=" expr)? ("," declarator ("=" expr)?)*)? ";"
+// declaration = declspec (";" | typedef | declarator ("=" expr)? ("," declarator ("=" expr)?)* ";")
 func declaration() []statement {
 	var ret []statement
-	ty := declSpec()
+	attr := &varAttr{}
+	baseTy := declSpec(attr)
+	if attr.isTypedef {
+		parseTypedef(baseTy)
+		return ret
+	}
+	if consume(";") {
+		return ret
+	}
 	for i := 0; ; i++ {
 		if i > 0 {
 			expect(",")
 		}
-		ty = declarator(ty)
-		lv := newNodeLocal(ty)
-		if consume("=") {
-			n := &assignNode{op: "=", lhs: lv, rhs: expr()}
-			ret = append(ret, &exprStmtNode{child: n})
+		ty := declarator(baseTy)
+
+		// A function declared in a block is defined outside of it, so it is
+		// not given a place on the stack.
+		if ty.kind == typeKindFunc {
+			pushScope(newGlobalVariable(ty))
+		} else {
+			checkIncompleteVariable(ty)
+			lv := newNodeLocal(ty)
+			if tok := tokens[0]; consume("=") {
+				n := &assignNode{tok: tok, op: "=", lhs: lv, rhs: expr()}
+				ret = append(ret, &exprStmtNode{tok: tok, child: n})
+			}
 		}
 		if consume(";") {
 			break
@@ -492,48 +991,64 @@ func declaration() []statement {
 	return ret
 }
 
+// returnStmt = "return" expr? ";"
+func returnStmt(tok *token) statement {
+	if consume(";") {
+		if currentFn.returnTy.kind != typeKindVoid {
+			warnTok(tok, "non-void function should return a value")
+		}
+		return &returnStmtNode{tok: tok}
+	}
+
+	n := expr()
+	if currentFn.returnTy.kind == typeKindVoid {
+		errorTok(n.getToken(), "void function should not return a value")
+	}
+	expect(";")
+	return &returnStmtNode{tok: tok, child: newCast(n, currentFn.returnTy)}
+}
+
 func stmt() statement {
+	tok := tokens[0]
 	if consume("return") {
-		ret := &returnStmtNode{child: expr()}
-		expect(";")
-		return ret
+		return returnStmt(tok)
 	} else if consume("{") {
 		return compoundStmt()
 	} else if consume("if") {
-		return ifStmt()
+		return ifStmt(tok)
 	} else if consume("while") {
-		return whileStmt()
+		return whileStmt(tok)
 	} else if consume("for") {
-		return forStmt()
+		return forStmt(tok)
 	} else {
 		ret := expr()
 		expect(";")
-		return &exprStmtNode{child: ret}
+		return &exprStmtNode{tok: tok, child: ret}
 	}
 }
 
-func ifStmt() statement {
+func ifStmt(tok *token) statement {
 	expect("(")
 	cond := expr()
 	expect(")")
 	then := stmt()
 	if consume("else") {
 		els := stmt()
-		return &ifStmtNode{cond: cond, then: then, els: els}
+		return &ifStmtNode{tok: tok, cond: cond, then: then, els: els}
 	} else {
-		return &ifStmtNode{cond: cond, then: then, els: nil}
+		return &ifStmtNode{tok: tok, cond: cond, then: then, els: nil}
 	}
 }
 
-func whileStmt() statement {
+func whileStmt(tok *token) statement {
 	expect("(")
 	cond := expr()
 	expect(")")
 	then := stmt()
-	return &forStmtNode{ini: nil, cond: cond, step: nil, then: then}
+	return &forStmtNode{tok: tok, ini: nil, cond: cond, step: nil, then: then}
 }
 
-func forStmt() statement {
+func forStmt(tok *token) statement {
 	expect("(")
 	var ini expression
 	for !consume(";") {
@@ -548,7 +1063,7 @@ func forStmt() statement {
 		step = expr()
 	}
 	then := stmt()
-	return &forStmtNode{ini: ini, cond: cond, step: step, then: then}
+	return &forStmtNode{tok: tok, ini: ini, cond: cond, step: step, then: then}
 }
 
 // expr = assign
@@ -559,8 +1074,8 @@ func expr() expression {
 // assign = equality ("=" assign)?
 func assign() expression {
 	ret := equality()
-	if consume("=") {
-		ret = &assignNode{op: "=", lhs: ret, rhs: assign()}
+	if tok := tokens[0]; consume("=") {
+		ret = &assignNode{tok: tok, op: "=", lhs: ret, rhs: assign()}
 	}
 	return ret
 }
@@ -569,30 +1084,48 @@ func assign() expression {
 func equality() expression {
 	ret := relational()
 	for {
+		tok := tokens[0]
 		switch {
 		case consume("=="):
-			ret = &binaryNode{op: "==", lhs: ret, rhs: relational()}
+			ret = &binaryNode{tok: tok, op: "==", lhs: ret, rhs: relational()}
 		case consume("!="):
-			ret = &binaryNode{op: "!=", lhs: ret, rhs: relational()}
+			ret = &binaryNode{tok: tok, op: "!=", lhs: ret, rhs: relational()}
 		default:
 			return ret
 		}
 	}
 }
 
-// relational = add ("<" add | "<=" add | ">" add | ">=" add)*
+// relational = shift ("<" shift | "<=" shift | ">" shift | ">=" shift)*
 func relational() expression {
-	ret := add()
+	ret := shift()
 	for {
+		tok := tokens[0]
 		switch {
 		case consume("<"):
-			ret = &binaryNode{op: "<", lhs: ret, rhs: add()}
+			ret = &binaryNode{tok: tok, op: "<", lhs: ret, rhs: shift()}
 		case consume("<="):
-			ret = &binaryNode{op: "<=", lhs: ret, rhs: add()}
+			ret = &binaryNode{tok: tok, op: "<=", lhs: ret, rhs: shift()}
 		case consume(">"):
-			ret = &binaryNode{op: "<", lhs: add(), rhs: ret}
+			ret = &binaryNode{tok: tok, op: "<", lhs: shift(), rhs: ret}
 		case consume(">="):
-			ret = &binaryNode{op: "<=", lhs: add(), rhs: ret}
+			ret = &binaryNode{tok: tok, op: "<=", lhs: shift(), rhs: ret}
+		default:
+			return ret
+		}
+	}
+}
+
+// shift = add ("<<" add | ">>" add)*
+func shift() expression {
+	ret := add()
+	for {
+		tok := tokens[0]
+		switch {
+		case consume("<<"):
+			ret = &binaryNode{tok: tok, op: "<<", lhs: ret, rhs: add()}
+		case consume(">>"):
+			ret = &binaryNode{tok: tok, op: ">>", lhs: ret, rhs: add()}
 		default:
 			return ret
 		}
@@ -603,66 +1136,88 @@ func relational() expression {
 func add() expression {
 	ret := mul()
 	for {
+		tok := tokens[0]
 		switch {
 		case consume("+"):
-			ret = newAddBinary(ret, mul())
+			ret = newAddBinary(tok, ret, mul())
 		case consume("-"):
-			ret = newSubBinary(ret, mul())
+			ret = newSubBinary(tok, ret, mul())
 		default:
 			return ret
 		}
 	}
 }
 
-// mul = unary ("*" unary | "/" unary)*
+// mul = cast ("*" cast | "/" cast | "%" cast)*
 func mul() expression {
-	ret := unary()
+	ret := castExpr()
 	for {
+		tok := tokens[0]
 		switch {
 		case consume("*"):
-			ret = &binaryNode{op: "*", lhs: ret, rhs: unary()}
+			ret = &binaryNode{tok: tok, op: "*", lhs: ret, rhs: castExpr()}
 		case consume("/"):
-			ret = &binaryNode{op: "/", lhs: ret, rhs: unary()}
+			ret = &binaryNode{tok: tok, op: "/", lhs: ret, rhs: castExpr()}
+		case consume("%"):
+			ret = &binaryNode{tok: tok, op: "%", lhs: ret, rhs: castExpr()}
 		default:
 			return ret
 		}
 	}
 }
 
-// unary = ("-" | "+" | "&" | "*") unary | postfix
+// cast = "(" type-name ")" cast | unary
+func castExpr() expression {
+	if tokens[0].val == "(" && isTypename(tokens[1]) {
+		advance()
+		ty := typeName()
+		expect(")")
+		return newCast(castExpr(), ty)
+	}
+	return unary()
+}
+
+// unary = ("-" | "+" | "&" | "*") cast | postfix
 func unary() expression {
+	tok := tokens[0]
 	switch {
 	case consume("-"):
-		return &binaryNode{op: "-", lhs: &intLit{val: 0}, rhs: unary()}
+		return &binaryNode{tok: tok, op: "-", lhs: &intLit{tok: tok, val: 0}, rhs: castExpr()}
 	case consume("+"):
-		return unary()
+		return castExpr()
 	case consume("&"):
-		return &addrNode{child: unary()}
+		return &addrNode{tok: tok, child: castExpr()}
 	case consume("*"):
-		return &derefNode{child: unary()}
+		return &derefNode{tok: tok, child: castExpr()}
 	default:
 		return postfix()
 	}
 }
 
-// postfix = primary ("[" expr "]" | "." ident | "->" ident)*
+// postfix = primary ("(" callArgs | "[" expr "]" | "." ident | "->" ident)*
 func postfix() expression {
 	ret := primary()
 
 	for {
+		tok := tokens[0]
+		if consume("(") {
+			ret = funcCall(tok, ret)
+			continue
+		}
+
 		if consume("[") {
-			ret = &derefNode{child: newAddBinary(ret, expr())}
+			ret = &derefNode{tok: tok, child: newAddBinary(tok, ret, expr())}
 			expect("]")
 			continue
 		}
 
 		if consume(".") {
-			ret = structRef(ret)
+			ret = structRef(tok, ret)
 			continue
 		}
 
 		if consume("->") {
-			ret = structRef(&derefNode{child: ret})
+			ret = structRef(tok, &derefNode{tok: tok, child: ret})
 			continue
 		}
 
@@ -670,31 +1225,36 @@ func postfix() expression {
 	}
 }
 
-func structRef(n expression) expression {
+func structRef(tok *token, n expression) expression {
 	addType(n)
 	ty := n.getType()
-	if ty.kind != typeKindStruct {
-		panic("expected struct type")
+	if ty.kind != typeKindStruct && ty.kind != typeKindUnion {
+		errorTok(tok, "not a struct nor a union")
+	}
+	if ty.isIncomplete {
+		errorTok(tok, "member access into incomplete type '%s'", tagName(ty))
 	}
 
-	tok := consumeToken(tokenKindIdent)
-	if tok == nil {
-		_, _ = fmt.Fprintln(os.Stderr, "ident expected", tok.val)
-		os.Exit(1)
+	memTok := consumeToken(tokenKindIdent)
+	if memTok == nil {
+		errorTok(tokens[0], "expected a member name")
 	}
 
 	var mem *member
 	for i := range ty.members {
 		m := ty.members[i]
-		if m.name == tok.val {
+		if m.name == memTok.val {
 			mem = m
 		}
 	}
+	if mem == nil {
+		errorTok(memTok, "no such member")
+	}
 
-	return &memberNode{unaryNode: unaryNode{child: n}, member: mem}
+	return &memberNode{unaryNode: unaryNode{tok: tok, child: n}, member: mem}
 }
 
-// primary = "(" expr ")" | "sizeof" unary | ident ("(" callArgs)? | num
+// primary = "(" expr ")" | "sizeof" "(" type-name ")" | "sizeof" unary | ident | str+ | num
 func primary() expression {
 	if consume("(") {
 		ret := expr()
@@ -702,33 +1262,112 @@ func primary() expression {
 		return ret
 	}
 
-	if consume("sizeof") {
-		n := unary()
-		addType(n)
-		return &intLit{val: n.getType().size}
+	if tok := tokens[0]; consume("sizeof") {
+		var ty *typ
+		if tokens[0].val == "(" && isTypename(tokens[1]) {
+			advance()
+			ty = typeName()
+			expect(")")
+		} else {
+			n := unary()
+			addType(n)
+			ty = n.getType()
+		}
+		if ty.kind == typeKindVoid && !optVoidPointerArith {
+			errorTok(tok, "invalid application of 'sizeof' to a void type")
+		}
+		if ty.isIncomplete {
+			errorTok(tok, "invalid application of 'sizeof' to an incomplete type '%s'", tagName(ty))
+		}
+		if ty.kind == typeKindArray && ty.length < 0 {
+			errorTok(tok, "invalid application of 'sizeof' to an array without a length")
+		}
+		return &intLit{tok: tok, val: ty.size, ty: newLiteralType("unsigned long")}
 	}
 
 	if tok := consumeToken(tokenKindIdent); tok != nil {
-		if consume("(") {
-			return &funcCallNode{name: tok.val, args: callArgs(), ty: newLiteralType("int")}
-		} else {
-			lv := findLocalInScope(tok.val)
-			if lv == nil {
-				_, _ = fmt.Fprintln(os.Stderr, "Undefined variable", tok.val)
-				os.Exit(1)
+		lv := findLocalInScope(tok.val)
+		if lv == nil {
+			// A call to an undeclared function.
+			if consume("(") {
+				return funcCall(tok, nil)
 			}
-
-			return lv
+			errorTok(tok, "undefined variable")
+		}
+		if lv.isEnum {
+			return &intLit{tok: tok, val: lv.enumVal, ty: newLiteralType("int")}
+		}
+		if lv.isTypedef {
+			errorTok(tok, "unexpected type name")
 		}
+
+		return lv
 	}
 
 	if tok := consumeToken(tokenKindStringLiteral); tok != nil {
-		return newStringLiteral(tok.str)
+		toks := []*token{tok}
+		for {
+			tok := consumeToken(tokenKindStringLiteral)
+			if tok == nil {
+				break
+			}
+			toks = append(toks, tok)
+		}
+		return newStringLiteral(toks)
 	}
 
 	return num()
 }
 
+// funcCall = callArgs
+// fn is the callee. If it is nil, tok names a function without a
+// declaration, which is assumed to return int.
+func funcCall(tok *token, fn expression) expression {
+	n := &funcCallNode{tok: tok, args: callArgs(), ty: newLiteralType("int")}
+
+	if fn == nil {
+		n.name = tok.val
+		// The default argument promotions
+		for i, arg := range n.args {
+			addType(arg)
+			if arg.getType().kind == typeKindFloat {
+				n.args[i] = newCast(arg, newLiteralType("double"))
+			}
+		}
+		return n
+	}
+
+	addType(fn)
+	ty := fn.getType()
+	if ty.kind == typeKindPtr && ty.base.kind == typeKindFunc {
+		ty = ty.base
+	}
+	if ty.kind != typeKindFunc {
+		errorTok(tok, "called object is not a function or function pointer")
+	}
+
+	// A function called by name is called directly.
+	if v, ok := fn.(*obj); ok && !v.isLocal && v.ty.kind == typeKindFunc {
+		n.name = v.name
+	} else {
+		n.fn = fn
+	}
+
+	n.ty = ty.returnTy
+	if ty.hasProto && (len(n.args) < len(ty.params) || (len(n.args) > len(ty.params) && !ty.isVariadic)) {
+		errorTok(tok, "wrong number of arguments")
+	}
+	for i, arg := range n.args {
+		addType(arg)
+		if i < len(ty.params) {
+			n.args[i] = newCast(arg, ty.params[i])
+		} else if arg.getType().kind == typeKindFloat {
+			n.args[i] = newCast(arg, newLiteralType("double"))
+		}
+	}
+	return n
+}
+
 // callArgs = (assign ("," assign)*)? ")"
 func callArgs() (args []expression) {
 	if consume(")") {
@@ -742,18 +1381,125 @@ func callArgs() (args []expression) {
 	return
 }
 
-func num() *intLit {
-	ret := &intLit{val: tokens[0].num}
-	advance()
-	return ret
+func num() expression {
+	tok := consumeToken(tokenKindNumberLiteral)
+	if tok == nil {
+		errorTok(tokens[0], "expected an expression")
+	}
+	if tok.ty.isFlonum() {
+		return &floatLit{tok: tok, val: tok.fval, ty: tok.ty}
+	}
+	return &intLit{tok: tok, val: tok.num, ty: tok.ty}
+}
+
+// constExpr reads an integer constant expression and returns its value.
+func constExpr() int {
+	return eval(expr())
+}
+
+// eval evaluates an integer constant expression.
+func eval(n expression) int {
+	addType(n)
+	switch n := n.(type) {
+	case *intLit:
+		return n.val
+	case *castNode:
+		if n.ty.isInteger() && n.child.getType().isInteger() {
+			return truncate(eval(n.child), n.ty)
+		}
+	case *binaryNode:
+		if !n.getType().isInteger() || !n.lhs.getType().isInteger() {
+			break
+		}
+		lhs, rhs := eval(n.lhs), eval(n.rhs)
+		unsigned := n.lhs.getType().isUnsigned
+		var v int
+		switch n.op {
+		case "+":
+			v = lhs + rhs
+		case "-":
+			v = lhs - rhs
+		case "*":
+			v = lhs * rhs
+		case "/", "%":
+			if rhs == 0 {
+				errorTok(n.tok, "division by zero")
+			}
+			switch {
+			case unsigned && n.op == "/":
+				v = int(uint64(lhs) / uint64(rhs))
+			case unsigned:
+				v = int(uint64(lhs) % uint64(rhs))
+			case n.op == "/":
+				v = lhs / rhs
+			default:
+				v = lhs % rhs
+			}
+		case "<<":
+			v = lhs << uint(rhs)
+		case ">>":
+			if unsigned {
+				v = int(uint64(lhs) >> uint(rhs))
+			} else {
+				v = lhs >> uint(rhs)
+			}
+		case "==":
+			v = boolToInt(lhs == rhs)
+		case "!=":
+			v = boolToInt(lhs != rhs)
+		case "<":
+			if unsigned {
+				v = boolToInt(uint64(lhs) < uint64(rhs))
+			} else {
+				v = boolToInt(lhs < rhs)
+			}
+		case "<=":
+			if unsigned {
+				v = boolToInt(uint64(lhs) <= uint64(rhs))
+			} else {
+				v = boolToInt(lhs <= rhs)
+			}
+		}
+		return truncate(v, n.getType())
+	}
+	errorTok(n.getToken(), "not a compile-time constant")
+	return 0
 }
 
-func newAddBinary(lhs, rhs expression) expression {
+// truncate converts the integer v to the integer type ty.
+func truncate(v int, ty *typ) int {
+	switch {
+	case ty.kind == typeKindBool:
+		return boolToInt(v != 0)
+	case ty.size == 1 && ty.isUnsigned:
+		return int(uint8(v))
+	case ty.size == 1:
+		return int(int8(v))
+	case ty.size == 2 && ty.isUnsigned:
+		return int(uint16(v))
+	case ty.size == 2:
+		return int(int16(v))
+	case ty.size == 4 && ty.isUnsigned:
+		return int(uint32(v))
+	case ty.size == 4:
+		return int(int32(v))
+	}
+	return v
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func newAddBinary(tok *token, lhs, rhs expression) expression {
 	addType(lhs)
 	addType(rhs)
 	// num + num
-	if lhs.getType().isInteger() && rhs.getType().isInteger() {
-		return &binaryNode{op: "+", lhs: lhs, rhs: rhs}
+	if lhs.getType().isNumeric() && rhs.getType().isNumeric() {
+		return &binaryNode{tok: tok, op: "+", lhs: lhs, rhs: rhs}
 	}
 
 	// canonicalize num + ptr to ptr + num
@@ -765,32 +1511,53 @@ func newAddBinary(lhs, rhs expression) expression {
 
 	// ptr + num
 	if lhs.getType().hasBase() && rhs.getType().isInteger() {
-		rhs = &binaryNode{op: "*", lhs: rhs, rhs: &intLit{val: lhs.getType().base.size}}
-		return &binaryNode{op: "+", lhs: lhs, rhs: rhs}
+		checkPointerArith(tok, lhs.getType())
+		rhs = &binaryNode{tok: tok, op: "*", lhs: rhs, rhs: &intLit{tok: tok, val: lhs.getType().base.size, ty: newLiteralType("long")}}
+		return &binaryNode{tok: tok, op: "+", lhs: lhs, rhs: rhs}
 	}
 
-	panic("invalid operands")
+	errorTok(tok, "invalid operands")
+	return nil
+}
+
+// checkPointerArith rejects arithmetic on a pointer to void, which is allowed
+// as a GNU extension with -fvoid-pointer-arith, where void has size 1. A
+// pointer to any other incomplete type is always rejected, as its size is
+// unknown.
+func checkPointerArith(tok *token, ty *typ) {
+	if ty.base.kind == typeKindVoid && !optVoidPointerArith {
+		errorTok(tok, "arithmetic on a pointer to void")
+	}
+	if ty.base.isIncomplete {
+		errorTok(tok, "arithmetic on a pointer to an incomplete type '%s'", tagName(ty.base))
+	}
+	if ty.base.kind == typeKindArray && ty.base.length < 0 {
+		errorTok(tok, "arithmetic on a pointer to an array without a length")
+	}
 }
 
-func newSubBinary(lhs, rhs expression) expression {
+func newSubBinary(tok *token, lhs, rhs expression) expression {
 	addType(lhs)
 	addType(rhs)
 	// num - num
-	if lhs.getType().isInteger() && rhs.getType().isInteger() {
-		return &binaryNode{op: "-", lhs: lhs, rhs: rhs}
+	if lhs.getType().isNumeric() && rhs.getType().isNumeric() {
+		return &binaryNode{tok: tok, op: "-", lhs: lhs, rhs: rhs}
 	}
 
 	// ptr - num
 	if lhs.getType().hasBase() && rhs.getType().isInteger() {
-		rhs = &binaryNode{op: "*", lhs: rhs, rhs: &intLit{val: lhs.getType().base.size}}
-		return &binaryNode{op: "-", lhs: lhs, rhs: rhs}
+		checkPointerArith(tok, lhs.getType())
+		rhs = &binaryNode{tok: tok, op: "*", lhs: rhs, rhs: &intLit{tok: tok, val: lhs.getType().base.size, ty: newLiteralType("long")}}
+		return &binaryNode{tok: tok, op: "-", lhs: lhs, rhs: rhs}
 	}
 
 	// ptr - ptr, which returns how many elements are between the two.
 	if lhs.getType().hasBase() && rhs.getType().hasBase() {
-		n := &binaryNode{op: "-", lhs: lhs, rhs: rhs, ty: newLiteralType("int")}
-		return &binaryNode{op: "/", lhs: n, rhs: &intLit{val: lhs.getType().base.size}}
+		checkPointerArith(tok, lhs.getType())
+		n := &binaryNode{tok: tok, op: "-", lhs: lhs, rhs: rhs, ty: newLiteralType("long")}
+		return &binaryNode{tok: tok, op: "/", lhs: n, rhs: &intLit{tok: tok, val: lhs.getType().base.size, ty: newLiteralType("long")}}
 	}
 
-	panic("invalid operands")
+	errorTok(tok, "invalid operands")
+	return nil
 }
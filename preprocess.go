@@ -0,0 +1,1506 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Include search paths, set by -iquote, -I and -isystem respectively.
+// "..." includes search the directory of the current file and then all of
+// them in this order; <...> includes skip quoteIncludePaths.
+var quoteIncludePaths []string
+var includePaths []string
+var systemIncludePaths []string
+
+func defaultIncludePaths() []string {
+	var paths []string
+	if exe, err := os.Executable(); err == nil {
+		paths = append(paths, filepath.Join(filepath.Dir(exe), "include"))
+	}
+	return append(paths, "/usr/local/include", "/usr/include/x86_64-linux-gnu", "/usr/include")
+}
+
+// preprocess runs the preprocessor over the tokens of the main file and
+// returns the tokens the parser consumes.
+func preprocess(toks []*token) []*token {
+	toks = preprocess2(toks)
+	if len(condIncls) > 0 {
+		errorTok(condIncls[len(condIncls)-1].tok, "unterminated conditional directive")
+	}
+	return toks
+}
+
+// preprocess2 expands macros and processes directives until the EOF token,
+// which is kept.
+func preprocess2(toks []*token) []*token {
+	var out []*token
+	buf := toks
+	for toks[0].kind != tokenKindEOF {
+		if body, rest, ok := expandMacro(toks); ok {
+			toks = prepend(&buf, body, rest)
+			continue
+		}
+
+		tok := toks[0]
+		if !isHash(tok) {
+			out = append(out, tok)
+			toks = toks[1:]
+			continue
+		}
+
+		toks = toks[1:]
+		tok = toks[0]
+
+		// A "#" alone on a line is a null directive.
+		if tok.atBOL {
+			continue
+		}
+
+		switch tok.val {
+		case "include":
+			included, rest := includeFile(tok, toks[1:])
+			toks = prepend(&buf, included, rest)
+			continue
+		case "define":
+			toks = readMacroDefinition(toks[1:])
+			continue
+		case "undef":
+			name := toks[1]
+			if name.kind != tokenKindIdent || name.atBOL {
+				errorTok(name, "macro name must be an identifier")
+			}
+			delete(macros, name.val)
+			toks = skipLine(toks[2:])
+			continue
+		case "if":
+			var val bool
+			val, toks = evalConstExpr(tok, toks[1:])
+			pushCondIncl(tok, val)
+			if !val {
+				toks = skipCondIncl(toks)
+			}
+			continue
+		case "ifdef", "ifndef":
+			var defined bool
+			defined, toks = readDefined(tok, toks[1:])
+			val := defined == (tok.val == "ifdef")
+			pushCondIncl(tok, val)
+			if !val {
+				toks = skipCondIncl(toks)
+			}
+			continue
+		case "elif", "elifdef", "elifndef":
+			ci := topCondIncl(tok)
+			if ci.ctx == inElse {
+				errorTok(tok, "#%s after #else", tok.val)
+			}
+			ci.ctx = inElif
+
+			if ci.included {
+				_, toks = readLine(toks[1:])
+				toks = skipCondIncl(toks)
+				continue
+			}
+
+			var val bool
+			if tok.val == "elif" {
+				val, toks = evalConstExpr(tok, toks[1:])
+			} else {
+				var defined bool
+				defined, toks = readDefined(tok, toks[1:])
+				val = defined == (tok.val == "elifdef")
+			}
+			if val {
+				ci.included = true
+			} else {
+				toks = skipCondIncl(toks)
+			}
+			continue
+		case "else":
+			ci := topCondIncl(tok)
+			if ci.ctx == inElse {
+				errorTok(tok, "#else after #else")
+			}
+			ci.ctx = inElse
+			toks = skipLine(toks[1:])
+			if ci.included {
+				toks = skipCondIncl(toks)
+			}
+			continue
+		case "endif":
+			topCondIncl(tok)
+			condIncls = condIncls[:len(condIncls)-1]
+			toks = skipLine(toks[1:])
+			continue
+		case "line":
+			toks = readLineMarker(tok, toks[1:])
+			continue
+		case "pragma":
+			if toks[1].val == "once" && !toks[1].atBOL {
+				pragmaOnce[canonicalPath(tok.file.name)] = true
+				toks = skipLine(toks[2:])
+				continue
+			}
+			var line []*token
+			line, toks = readLine(toks[1:])
+			if len(line) > 0 {
+				warnTok(line[0], "unknown pragma ignored")
+			}
+			continue
+		case "error":
+			line, _ := readLine(toks[1:])
+			errorTok(tok, "#error %s", joinTokens(line))
+		case "warning":
+			var line []*token
+			line, toks = readLine(toks[1:])
+			warnTok(tok, "#warning %s", joinTokens(line))
+			continue
+		}
+
+		// A GNU line marker "# 10 "foo.c"", as written by -E.
+		if tok.kind == tokenKindNumberLiteral {
+			toks = readLineMarker(tok, toks[1:])
+			continue
+		}
+
+		errorTok(tok, "invalid preprocessor directive")
+	}
+	return append(out, toks[0])
+}
+
+// prepend returns body followed by rest, the unread tail of *buf. Copying rest
+// after every expansion would make preprocessing quadratic, so body is put in
+// the slots before rest, which have been read already. When there is not
+// enough room, *buf is reallocated with as much room in front as it holds.
+func prepend(buf *[]*token, body, rest []*token) []*token {
+	if len(body) == 0 {
+		return rest
+	}
+	start := len(*buf) - len(rest)
+	if start >= len(body) && &(*buf)[start] == &rest[0] {
+		toks := (*buf)[start-len(body):]
+		copy(toks, body)
+		return toks
+	}
+
+	room := len(body) + len(rest)
+	b := make([]*token, room+len(body)+len(rest))
+	copy(b[room:], body)
+	copy(b[room+len(body):], rest)
+	*buf = b
+	return b[room:]
+}
+
+func isHash(tok *token) bool {
+	return tok.atBOL && tok.kind == tokenKindReserved && tok.val == "#"
+}
+
+// skipLine skips the rest of the current directive line. Tokens found there
+// are reported as extra.
+func skipLine(toks []*token) []*token {
+	if toks[0].atBOL {
+		return toks
+	}
+	warnTok(toks[0], "extra token")
+	for !toks[0].atBOL {
+		toks = toks[1:]
+	}
+	return toks
+}
+
+// readLine splits toks into the tokens up to the end of the current line and the rest.
+func readLine(toks []*token) ([]*token, []*token) {
+	i := 0
+	for !toks[i].atBOL {
+		i++
+	}
+	return toks[:i], toks[i:]
+}
+
+// newEOF returns an EOF token at the location of tmpl.
+func newEOF(tmpl *token) *token {
+	tok := copyToken(tmpl)
+	tok.kind = tokenKindEOF
+	tok.val = ""
+	return tok
+}
+
+// expandLine fully macro-expands line, which must not contain directives.
+func expandLine(line []*token, tmpl *token) []*token {
+	toks := make([]*token, len(line), len(line)+1)
+	copy(toks, line)
+	toks = preprocess2(append(toks, newEOF(tmpl)))
+	return toks[:len(toks)-1]
+}
+
+// joinTokens concatenates the spellings of toks, keeping a single space where
+// the source had whitespace between them.
+func joinTokens(toks []*token) string {
+	var sb strings.Builder
+	for i, tok := range toks {
+		if i > 0 && tok.hasSpace {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(tok.val)
+	}
+	return sb.String()
+}
+
+// includeFile handles `#include "foo.h"` and `#include <foo.h>`. It returns
+// the tokens of the included file without EOF and the rest of the current file.
+func includeFile(directive *token, toks []*token) ([]*token, []*token) {
+	line, toks := readLine(toks)
+
+	// #include FOO, where FOO expands to one of the two forms.
+	if len(line) > 0 && line[0].kind == tokenKindIdent {
+		line = expandLine(line, directive)
+	}
+
+	name, quoted, n := readHeaderName(directive, line)
+	if n < len(line) {
+		warnTok(line[n], "extra token")
+	}
+
+	path := searchInclude(name, quoted, directive.file)
+	if path == "" {
+		errorTok(line[0], "%s: file not found", name)
+	}
+
+	// Skip a file with "#pragma once" or whose include guard is defined
+	// without reading it again.
+	key := canonicalPath(path)
+	if pragmaOnce[key] {
+		return nil, toks
+	}
+	if guard, ok := includeGuards[key]; ok {
+		if _, defined := macros[guard]; defined {
+			return nil, toks
+		}
+	}
+
+	depth := directive.file.includeDepth + 1
+	if depth >= maxIncludeDepth {
+		errorTok(directive, "#include nested depth %d exceeds maximum of %d", depth, maxIncludeDepth)
+	}
+	included := tokenizeFile(path)
+	included[0].file.includeDepth = depth
+	if guard := detectIncludeGuard(included); guard != "" {
+		includeGuards[key] = guard
+	}
+	return included[:len(included)-1], toks
+}
+
+// maxIncludeDepth limits the nesting of #include, which would not end for a
+// file that includes itself without a guard.
+const maxIncludeDepth = 200
+
+// pragmaOnce is the set of files that have "#pragma once".
+var pragmaOnce = map[string]bool{}
+
+// includeGuards maps files to the macros that guard their whole contents.
+var includeGuards = map[string]string{}
+
+func canonicalPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// detectIncludeGuard returns the macro name X if the file is guarded as
+//
+//	#ifndef X
+//	#define X
+//	...
+//	#endif
+//
+// with nothing but whitespace and comments outside. Otherwise it returns "".
+func detectIncludeGuard(toks []*token) string {
+	if !isHash(toks[0]) || toks[1].val != "ifndef" || toks[1].atBOL {
+		return ""
+	}
+	guard := toks[2]
+	if guard.kind != tokenKindIdent || guard.atBOL || !toks[3].atBOL {
+		return ""
+	}
+	toks = toks[3:]
+	if !isHash(toks[0]) || toks[1].val != "define" || toks[1].atBOL || toks[2].val != guard.val || toks[2].atBOL {
+		return ""
+	}
+
+	// The matching #endif must end the file, with no other branch that
+	// could be taken when the file is included again.
+	for toks[0].kind != tokenKindEOF {
+		if isHash(toks[0]) && !toks[1].atBOL {
+			dir := toks[1]
+			if isDirective(dir, "if", "ifdef", "ifndef") {
+				toks = skipCondIncl2(toks[2:])
+				continue
+			}
+			if isDirective(dir, "else", "elif", "elifdef", "elifndef") {
+				return ""
+			}
+			if isDirective(dir, "endif") {
+				toks = skipLine(toks[2:])
+				if toks[0].kind == tokenKindEOF {
+					return guard.val
+				}
+				return ""
+			}
+		}
+		toks = toks[1:]
+	}
+	return ""
+}
+
+// readLineMarker handles "#line 10 "foo.c"" after "line", and the GNU line
+// marker "# 10 "foo.c" flags...". The line after the directive gets the line
+// number and, if given, the file name.
+func readLineMarker(directive *token, toks []*token) []*token {
+	line, toks := readLine(toks)
+	if directive.val == "line" {
+		line = expandLine(line, directive)
+	} else {
+		line = append([]*token{directive}, line...)
+	}
+
+	if len(line) == 0 || line[0].kind != tokenKindNumberLiteral {
+		errorTok(directive, "#line directive requires a simple digit sequence")
+	}
+	lineNo, err := strconv.Atoi(line[0].val)
+	if err != nil || lineNo < 0 || strings.Trim(line[0].val, "0123456789") != "" {
+		errorTok(line[0], "#line directive requires a simple digit sequence")
+	}
+
+	filename := directive.displayName()
+	if len(line) > 1 {
+		if line[1].kind != tokenKindStringLiteral || line[1].err != "" || stringPrefix(line[1]) != "" {
+			errorTok(line[1], "invalid filename")
+		}
+		filename = strings.TrimSuffix(string(encodeString(line[1].chars, newLiteralType("char"))), "\000")
+		if directive.val == "line" && len(line) > 2 {
+			warnTok(line[2], "extra token")
+		}
+	}
+
+	// The rest of the file continues from the new location.
+	delta := lineNo - (directive.line + 1)
+	for _, t := range toks {
+		if t.file == directive.file {
+			t.filename = filename
+			t.lineDelta = delta
+		}
+	}
+	return toks
+}
+
+// readHeaderName reads "foo.h" or <foo.h> from the start of line and returns
+// the name, whether it was quoted, and the number of tokens read.
+func readHeaderName(directive *token, line []*token) (string, bool, int) {
+	if len(line) == 0 {
+		errorTok(directive, "expected a filename")
+	}
+
+	start := line[0]
+	if start.kind == tokenKindStringLiteral && stringPrefix(start) == "" {
+		if start.err == "unclosed string literal" {
+			errorTok(start, "%s", start.err)
+		}
+		// The name is not a string literal, so escape sequences are not decoded.
+		return start.val[1 : len(start.val)-1], true, 1
+	}
+
+	if start.val == "<" {
+		for i := 1; i < len(line); i++ {
+			if line[i].val == ">" {
+				return joinTokens(line[1:i]), false, i + 1
+			}
+		}
+		errorTok(start, "expected '>'")
+	}
+
+	errorTok(start, "expected a filename")
+	return "", false, 0
+}
+
+// searchInclude returns the path of the header name or "" if it is not found.
+func searchInclude(name string, quoted bool, from *file) string {
+	if filepath.IsAbs(name) {
+		if fileExists(name) {
+			return name
+		}
+		return ""
+	}
+
+	var dirs []string
+	if quoted {
+		dirs = append(dirs, filepath.Dir(from.name))
+		dirs = append(dirs, quoteIncludePaths...)
+	}
+	dirs = append(dirs, includePaths...)
+	dirs = append(dirs, systemIncludePaths...)
+	dirs = append(dirs, defaultIncludePaths()...)
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && !st.IsDir()
+}
+
+type macro struct {
+	name      string
+	isObjlike bool // object-like or function-like
+	params    []string
+	vaArgs    string // the name of the variadic parameter, "" if not variadic
+	body      []*token
+	handler   func(tok *token) *token // computes the expansion of a dynamic macro
+}
+
+var macros = map[string]*macro{}
+
+type macroArg struct {
+	name     string
+	toks     []*token
+	expanded []*token
+}
+
+func copyToken(tok *token) *token {
+	t := *tok
+	return &t
+}
+
+func hidesetContains(hs []string, name string) bool {
+	for _, s := range hs {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hidesetUnion(hs1, hs2 []string) []string {
+	ret := append([]string{}, hs1...)
+	for _, s := range hs2 {
+		if !hidesetContains(ret, s) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func hidesetIntersection(hs1, hs2 []string) []string {
+	var ret []string
+	for _, s := range hs1 {
+		if hidesetContains(hs2, s) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// readMacroDefinition reads `#define` after the directive name.
+func readMacroDefinition(toks []*token) []*token {
+	name := toks[0]
+	if name.kind != tokenKindIdent || name.atBOL {
+		errorTok(name, "macro name must be an identifier")
+	}
+	toks = toks[1:]
+
+	m := &macro{name: name.val, isObjlike: true}
+
+	// A function-like macro has no space between its name and "(".
+	if toks[0].val == "(" && !toks[0].hasSpace && !toks[0].atBOL {
+		m.isObjlike = false
+		m.params, m.vaArgs, toks = readMacroParams(toks[1:])
+	}
+
+	// The body is copied out of toks, whose slots are reused by prepend.
+	var body []*token
+	body, toks = readLine(toks)
+	m.body = append([]*token{}, body...)
+	if len(m.body) > 0 {
+		m.body[0] = copyToken(m.body[0])
+		m.body[0].hasSpace = false
+	}
+
+	if old, ok := macros[m.name]; ok && !sameMacro(old, m) {
+		warnTok(name, "'%s' macro redefined", m.name)
+	}
+	macros[m.name] = m
+	return toks
+}
+
+// readMacroParams reads the parameter list after "(" up to and including ")".
+func readMacroParams(toks []*token) ([]string, string, []*token) {
+	var params []string
+	for i := 0; toks[0].val != ")"; i++ {
+		if toks[0].atBOL {
+			errorTok(toks[0], "expected ')'")
+		}
+		if i > 0 {
+			if toks[0].val != "," {
+				errorTok(toks[0], "expected ','")
+			}
+			toks = toks[1:]
+		}
+
+		if toks[0].val == "..." {
+			if toks[1].val != ")" || toks[1].atBOL {
+				errorTok(toks[1], "expected ')'")
+			}
+			return params, "__VA_ARGS__", toks[2:]
+		}
+
+		if toks[0].kind != tokenKindIdent || toks[0].atBOL {
+			errorTok(toks[0], "expected a parameter name")
+		}
+		name := toks[0].val
+		toks = toks[1:]
+
+		// [GNU] A named variadic parameter such as "args...".
+		if toks[0].val == "..." {
+			if toks[1].val != ")" || toks[1].atBOL {
+				errorTok(toks[1], "expected ')'")
+			}
+			return params, name, toks[2:]
+		}
+
+		params = append(params, name)
+	}
+	return params, "", toks[1:]
+}
+
+func sameMacro(m1, m2 *macro) bool {
+	if m1.isObjlike != m2.isObjlike || m1.vaArgs != m2.vaArgs || len(m1.params) != len(m2.params) || len(m1.body) != len(m2.body) {
+		return false
+	}
+	for i := range m1.params {
+		if m1.params[i] != m2.params[i] {
+			return false
+		}
+	}
+	for i := range m1.body {
+		if m1.body[i].val != m2.body[i].val || (i > 0 && m1.body[i].hasSpace != m2.body[i].hasSpace) {
+			return false
+		}
+	}
+	return true
+}
+
+// expandMacro expands the macro at the start of toks, if any, and returns
+// the expansion and the rest of the tokens; the expansion is then rescanned
+// before the rest.
+func expandMacro(toks []*token) ([]*token, []*token, bool) {
+	tok := toks[0]
+	if tok.kind != tokenKindIdent || hidesetContains(tok.hideset, tok.val) {
+		return nil, toks, false
+	}
+	m, ok := macros[tok.val]
+	if !ok {
+		return nil, toks, false
+	}
+
+	if m.handler != nil {
+		return expansion([]*token{m.handler(tok)}, tok, nil), toks[1:], true
+	}
+
+	if m.isObjlike {
+		body := substTokens(m, m.body, nil)
+		return expansion(body, tok, hidesetUnion(tok.hideset, []string{m.name})), toks[1:], true
+	}
+
+	// A function-like macro name not followed by "(" is an ordinary identifier.
+	if toks[1].val != "(" {
+		return nil, toks, false
+	}
+
+	args, rparen, rest := readMacroArgs(tok, toks[2:], m)
+
+	// The expansion is hidden from the macros hidden at both the macro name
+	// and the closing parenthesis, plus the macro itself.
+	hs := hidesetIntersection(tok.hideset, rparen.hideset)
+	hs = hidesetUnion(hs, []string{m.name})
+
+	body := subst(m, args)
+	return expansion(body, tok, hs), rest, true
+}
+
+// expansion finishes a macro expansion: it marks the tokens with their hide set
+// and origin, and lets the first one take the place of the macro name.
+func expansion(body []*token, name *token, hs []string) []*token {
+	for _, t := range body {
+		t.hideset = hidesetUnion(t.hideset, hs)
+		t.origin = name
+		if name.origin != nil {
+			t.origin = name.origin
+		}
+	}
+	if len(body) > 0 {
+		body[0].atBOL = name.atBOL
+		body[0].hasSpace = name.hasSpace
+	}
+	return body
+}
+
+// readMacroArgs reads the arguments of a function-like macro after "(".
+// It returns them with the closing parenthesis and the rest of the tokens.
+func readMacroArgs(name *token, toks []*token, m *macro) ([]*macroArg, *token, []*token) {
+	var args []*macroArg
+
+	for i, p := range m.params {
+		if i > 0 {
+			if toks[0].val != "," {
+				errorTok(name, "too few arguments to macro '%s'", m.name)
+			}
+			toks = toks[1:]
+		}
+		arg := &macroArg{name: p}
+		arg.toks, toks = readMacroArg(name, toks, false)
+		args = append(args, arg)
+	}
+
+	if m.vaArgs != "" {
+		arg := &macroArg{name: m.vaArgs}
+		switch {
+		case len(m.params) == 0:
+			arg.toks, toks = readMacroArg(name, toks, true)
+		case toks[0].val == ",":
+			arg.toks, toks = readMacroArg(name, toks[1:], true)
+		}
+		args = append(args, arg)
+	} else if len(m.params) == 0 && toks[0].val != ")" {
+		errorTok(name, "too many arguments to macro '%s'", m.name)
+	}
+
+	if toks[0].val != ")" {
+		errorTok(name, "too many arguments to macro '%s'", m.name)
+	}
+	return args, toks[0], toks[1:]
+}
+
+// readMacroArg reads one argument up to "," or ")" at the outermost level.
+// The variadic argument also takes the commas.
+func readMacroArg(name *token, toks []*token, readRest bool) ([]*token, []*token) {
+	level := 0
+	i := 0
+	for ; ; i++ {
+		tok := toks[i]
+		if tok.kind == tokenKindEOF {
+			errorTok(name, "unterminated argument list invoking macro '%s'", name.val)
+		}
+		if level == 0 && (tok.val == ")" || (tok.val == "," && !readRest)) {
+			break
+		}
+		if tok.val == "(" {
+			level++
+		} else if tok.val == ")" {
+			level--
+		}
+	}
+	return toks[:i], toks[i:]
+}
+
+func findArg(args []*macroArg, tok *token) *macroArg {
+	if tok == nil || tok.kind != tokenKindIdent {
+		return nil
+	}
+	for _, arg := range args {
+		if arg.name == tok.val {
+			return arg
+		}
+	}
+	return nil
+}
+
+// expandArg returns the fully macro-expanded argument.
+func expandArg(arg *macroArg, tmpl *token) []*token {
+	if arg.expanded == nil {
+		arg.expanded = expandLine(arg.toks, tmpl)
+	}
+	return arg.expanded
+}
+
+func copyTokens(toks []*token) []*token {
+	ret := make([]*token, len(toks))
+	for i, t := range toks {
+		ret[i] = copyToken(t)
+	}
+	return ret
+}
+
+// subst replaces the parameters in the body of m with the arguments.
+func subst(m *macro, args []*macroArg) []*token {
+	return substTokens(m, m.body, args)
+}
+
+func substTokens(m *macro, body []*token, args []*macroArg) []*token {
+	var out []*token
+
+	at := func(i int) *token {
+		if i < len(body) {
+			return body[i]
+		}
+		return nil
+	}
+
+	for i := 0; i < len(body); {
+		tok := body[i]
+
+		// "#" followed by a parameter is replaced with the stringized argument.
+		// In an object-like macro, "#" is an ordinary token.
+		if tok.kind == tokenKindReserved && tok.val == "#" && !m.isObjlike {
+			arg := findArg(args, at(i+1))
+			if arg == nil {
+				errorTok(tok, "'#' is not followed by a macro parameter")
+			}
+			out = append(out, stringize(tok, arg.toks))
+			i += 2
+			continue
+		}
+
+		// [GNU] ", ## __VA_ARGS__" drops the comma if the variadic argument is empty.
+		if tok.val == "," && at(i+1) != nil && at(i+1).val == "##" {
+			if arg := findArg(args, at(i+2)); arg != nil && arg.name == m.vaArgs {
+				if len(arg.toks) == 0 {
+					i += 3
+				} else {
+					out = append(out, copyToken(tok))
+					i += 2
+				}
+				continue
+			}
+		}
+
+		if tok.kind == tokenKindReserved && tok.val == "##" {
+			if len(out) == 0 {
+				errorTok(tok, "'##' cannot appear at start of macro expansion")
+			}
+			rhs := at(i + 1)
+			if rhs == nil {
+				errorTok(tok, "'##' cannot appear at end of macro expansion")
+			}
+
+			if arg := findArg(args, rhs); arg != nil {
+				if len(arg.toks) > 0 {
+					out[len(out)-1] = paste(out[len(out)-1], arg.toks[0])
+					out = append(out, copyTokens(arg.toks[1:])...)
+				}
+				i += 2
+				continue
+			}
+
+			out[len(out)-1] = paste(out[len(out)-1], rhs)
+			i += 2
+			continue
+		}
+
+		arg := findArg(args, tok)
+
+		// A parameter next to "##" is replaced with the argument unexpanded.
+		if arg != nil && at(i+1) != nil && at(i+1).val == "##" {
+			if len(arg.toks) == 0 {
+				// An empty argument is a placemarker; the right operand is used as is.
+				rhs := at(i + 2)
+				if rhs == nil {
+					errorTok(at(i+1), "'##' cannot appear at end of macro expansion")
+				}
+				if rhsArg := findArg(args, rhs); rhsArg != nil {
+					out = append(out, copyTokens(rhsArg.toks)...)
+				} else {
+					out = append(out, copyToken(rhs))
+				}
+				i += 3
+				continue
+			}
+			out = append(out, copyTokens(arg.toks)...)
+			i++
+			continue
+		}
+
+		// __VA_OPT__(x) is replaced with x only if the variadic argument is not empty.
+		if m.vaArgs != "" && tok.val == "__VA_OPT__" && at(i+1) != nil && at(i+1).val == "(" {
+			level := 0
+			j := i + 2
+			for ; j < len(body); j++ {
+				if body[j].val == "(" {
+					level++
+				} else if body[j].val == ")" {
+					if level == 0 {
+						break
+					}
+					level--
+				}
+			}
+			if j == len(body) {
+				errorTok(tok, "unterminated __VA_OPT__")
+			}
+			if va := findArg(args, &token{kind: tokenKindIdent, val: m.vaArgs}); va != nil && len(expandArg(va, tok)) > 0 {
+				out = append(out, substTokens(m, body[i+2:j], args)...)
+			}
+			i = j + 1
+			continue
+		}
+
+		if arg != nil {
+			expanded := copyTokens(expandArg(arg, tok))
+			if len(expanded) > 0 {
+				expanded[0].hasSpace = tok.hasSpace
+			}
+			out = append(out, expanded...)
+			i++
+			continue
+		}
+
+		out = append(out, copyToken(tok))
+		i++
+	}
+	return out
+}
+
+// stringize returns a string literal token spelling toks, as "#" does.
+// Backslashes and double quotes are escaped only inside string literals and
+// character constants.
+func stringize(hash *token, toks []*token) *token {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i, t := range toks {
+		if i > 0 && t.hasSpace {
+			sb.WriteByte(' ')
+		}
+		if t.kind == tokenKindStringLiteral || (t.kind == tokenKindNumberLiteral && t.val[0] == '\'') {
+			sb.WriteString(escapeString(t.val))
+		} else {
+			sb.WriteString(t.val)
+		}
+	}
+	sb.WriteByte('"')
+
+	tok := newTokenFrom(sb.String(), hash)
+	tok.hasSpace = hash.hasSpace
+	return tok
+}
+
+// quoteString returns s as a string literal.
+func quoteString(s string) string {
+	return "\"" + escapeString(s) + "\""
+}
+
+// escapeString escapes the backslashes and double quotes in s.
+func escapeString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// paste concatenates two tokens with "##"; the result must be a single token.
+func paste(lhs, rhs *token) *token {
+	tok := newTokenFrom(lhs.val+rhs.val, lhs)
+	if tok == nil {
+		errorTok(lhs, "pasting \"%s\" and \"%s\" does not give a valid preprocessing token", lhs.val, rhs.val)
+	}
+	tok.hasSpace = lhs.hasSpace
+	tok.atBOL = lhs.atBOL
+	return tok
+}
+
+// newTokenFrom tokenizes s, which is made up by the preprocessor at the
+// location of tmpl. It returns nil if s is not exactly one token.
+func newTokenFrom(s string, tmpl *token) *token {
+	toks := tokenize(newFile(tmpl.file.name, s))
+	if len(toks) != 2 {
+		return nil
+	}
+	tok := toks[0]
+	tok.file, tok.offset = tmpl.file, tmpl.offset
+	tok.line, tok.col = tmpl.line, tmpl.col
+	tok.filename, tok.lineDelta = tmpl.filename, tmpl.lineDelta
+	tok.hideset = tmpl.hideset
+	tok.origin = tmpl.origin
+	return tok
+}
+
+// defineMacro defines a macro as if by "#define name body". The name may
+// include a parameter list.
+func defineMacro(name, body string) {
+	toks := tokenize(newFile("<built-in>", name+" "+body))
+	toks[0].atBOL = false
+	readMacroDefinition(toks)
+}
+
+func undefMacro(name string) {
+	delete(macros, name)
+}
+
+func addBuiltin(name string, handler func(tok *token) *token) {
+	macros[name] = &macro{name: name, isObjlike: true, handler: handler}
+}
+
+// initMacros defines the predefined macros.
+func initMacros() {
+	defineMacro("__STDC__", "1")
+	defineMacro("__STDC_VERSION__", "201112L")
+	defineMacro("__STDC_HOSTED__", "1")
+	defineMacro("__STDC_NO_ATOMICS__", "1")
+	defineMacro("__STDC_NO_COMPLEX__", "1")
+	defineMacro("__STDC_NO_THREADS__", "1")
+	defineMacro("__STDC_NO_VLA__", "1")
+	defineMacro("__x86_64", "1")
+	defineMacro("__x86_64__", "1")
+	defineMacro("__amd64", "1")
+	defineMacro("__amd64__", "1")
+	defineMacro("_LP64", "1")
+	defineMacro("__LP64__", "1")
+	defineMacro("__linux", "1")
+	defineMacro("__linux__", "1")
+	defineMacro("__gnu_linux__", "1")
+	defineMacro("__unix", "1")
+	defineMacro("__unix__", "1")
+	defineMacro("__ELF__", "1")
+	defineMacro("__CHAR_BIT__", "8")
+	defineMacro("__SIZEOF_SHORT__", "2")
+	defineMacro("__SIZEOF_INT__", "4")
+	defineMacro("__SIZEOF_LONG__", "8")
+	defineMacro("__SIZEOF_LONG_LONG__", "8")
+	defineMacro("__SIZEOF_POINTER__", "8")
+	defineMacro("__SIZEOF_FLOAT__", "4")
+	defineMacro("__SIZEOF_DOUBLE__", "8")
+	defineMacro("__ORDER_LITTLE_ENDIAN__", "1234")
+	defineMacro("__ORDER_BIG_ENDIAN__", "4321")
+	defineMacro("__BYTE_ORDER__", "__ORDER_LITTLE_ENDIAN__")
+
+	// __DATE__ and __TIME__ are the time the compilation started.
+	now := time.Now()
+	defineMacro("__DATE__", quoteString(now.Format("Jan _2 2006")))
+	defineMacro("__TIME__", quoteString(now.Format("15:04:05")))
+
+	// __FILE__ and __LINE__ expand to the location of the outermost macro
+	// invocation, not to where they appear in a macro body.
+	addBuiltin("__FILE__", func(tok *token) *token {
+		tok = sourceToken(tok)
+		return newTokenFrom(quoteString(tok.displayName()), tok)
+	})
+	addBuiltin("__LINE__", func(tok *token) *token {
+		tok = sourceToken(tok)
+		return newTokenFrom(strconv.Itoa(tok.displayLine()), tok)
+	})
+	counter := 0
+	addBuiltin("__COUNTER__", func(tok *token) *token {
+		counter++
+		return newTokenFrom(strconv.Itoa(counter-1), tok)
+	})
+}
+
+// sourceToken returns the token in the source file that tok was expanded from.
+func sourceToken(tok *token) *token {
+	if tok.origin != nil {
+		return tok.origin
+	}
+	return tok
+}
+
+// printTokens writes preprocessed tokens as source text for -E. Line markers
+// "# <line> "<file>"" are written when the output moves to another file or
+// jumps over more lines than it is worth writing empty lines for.
+func printTokens(w io.Writer, toks []*token) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	var f *file
+	name, line := "", 0
+	var prev *token
+	for _, tok := range toks {
+		if tok.kind == tokenKindEOF {
+			break
+		}
+
+		// Tokens from a macro expansion are placed at the macro invocation.
+		loc := sourceToken(tok)
+		l := loc.displayLine()
+		switch {
+		case loc.file != f || loc.displayName() != name || l < line || l > line+8:
+			if f != nil {
+				bw.WriteByte('\n')
+			}
+			f, name, line = loc.file, loc.displayName(), l
+			fmt.Fprintf(bw, "# %d %s\n", line, quoteString(name))
+			bw.WriteString(strings.Repeat(" ", loc.col-1))
+		case l > line:
+			bw.WriteString(strings.Repeat("\n", l-line))
+			line = l
+			bw.WriteString(strings.Repeat(" ", loc.col-1))
+		case tok.hasSpace || prev != nil && wouldPaste(prev, tok):
+			bw.WriteByte(' ')
+		}
+
+		bw.WriteString(tok.val)
+		prev = tok
+	}
+	bw.WriteByte('\n')
+}
+
+// wouldPaste reports whether lhs and rhs would be read back as different
+// tokens if written without a space between them. Only macro expansion can
+// bring such tokens together.
+func wouldPaste(lhs, rhs *token) bool {
+	if lhs.origin == nil && rhs.origin == nil {
+		return false
+	}
+	toks := tokenize(newFile(lhs.file.name, lhs.val+rhs.val))
+	return len(toks) != 3 || toks[0].val != lhs.val
+}
+
+const (
+	inThen = iota
+	inElif
+	inElse
+)
+
+// condIncl is an #if, #ifdef or #ifndef group being processed.
+type condIncl struct {
+	ctx      int
+	tok      *token
+	included bool // whether one of the groups has been included
+}
+
+var condIncls []*condIncl
+
+func pushCondIncl(tok *token, included bool) {
+	condIncls = append(condIncls, &condIncl{ctx: inThen, tok: tok, included: included})
+}
+
+// topCondIncl returns the innermost group, which directive tok continues.
+func topCondIncl(tok *token) *condIncl {
+	if len(condIncls) == 0 {
+		errorTok(tok, "#%s without #if", tok.val)
+	}
+	return condIncls[len(condIncls)-1]
+}
+
+func isDirective(tok *token, names ...string) bool {
+	for _, name := range names {
+		if tok.val == name {
+			return true
+		}
+	}
+	return false
+}
+
+// skipCondIncl skips a group until the #elif, #else or #endif that ends it.
+// Nested groups are skipped as a whole. The tokens in skipped groups are
+// never converted, so they may contain invalid code.
+func skipCondIncl(toks []*token) []*token {
+	for toks[0].kind != tokenKindEOF {
+		if isHash(toks[0]) && !toks[1].atBOL {
+			dir := toks[1]
+			if isDirective(dir, "if", "ifdef", "ifndef") {
+				toks = skipCondIncl2(toks[2:])
+				continue
+			}
+			if isDirective(dir, "elif", "elifdef", "elifndef", "else", "endif") {
+				return toks
+			}
+		}
+		toks = toks[1:]
+	}
+	return toks
+}
+
+// skipCondIncl2 skips a nested group including its #endif.
+func skipCondIncl2(toks []*token) []*token {
+	for toks[0].kind != tokenKindEOF {
+		if isHash(toks[0]) && !toks[1].atBOL {
+			dir := toks[1]
+			if isDirective(dir, "if", "ifdef", "ifndef") {
+				toks = skipCondIncl2(toks[2:])
+				continue
+			}
+			if isDirective(dir, "endif") {
+				return toks[2:]
+			}
+		}
+		toks = toks[1:]
+	}
+	return toks
+}
+
+// readDefined reads the macro name of #ifdef, #ifndef, #elifdef and #elifndef.
+func readDefined(directive *token, toks []*token) (bool, []*token) {
+	name := toks[0]
+	if name.kind != tokenKindIdent || name.atBOL {
+		errorTok(directive, "macro name must be an identifier")
+	}
+	return isDefined(name.val), skipLine(toks[1:])
+}
+
+func isDefined(name string) bool {
+	_, ok := macros[name]
+	return ok || name == "__has_include"
+}
+
+// newNumberToken returns an integer token with the value val at the location of tmpl.
+func newNumberToken(val int, tmpl *token) *token {
+	tok := copyToken(tmpl)
+	tok.kind = tokenKindNumberLiteral
+	tok.val = strconv.Itoa(val)
+	tok.ty = nil
+	tok.err = ""
+	return tok
+}
+
+// evalConstExpr reads and evaluates the controlling expression of #if or #elif.
+func evalConstExpr(directive *token, toks []*token) (bool, []*token) {
+	line, rest := readLine(toks)
+	if len(line) == 0 {
+		errorTok(directive, "no expression")
+	}
+
+	// "defined" and "__has_include" are replaced before macro expansion.
+	var expr []*token
+	for i := 0; i < len(line); i++ {
+		tok := line[i]
+
+		if tok.kind == tokenKindIdent && tok.val == "defined" {
+			i++
+			paren := i < len(line) && line[i].val == "("
+			if paren {
+				i++
+			}
+			if i >= len(line) || line[i].kind != tokenKindIdent {
+				errorTok(tok, "macro name must be an identifier")
+			}
+			val := 0
+			if isDefined(line[i].val) {
+				val = 1
+			}
+			if paren {
+				i++
+				if i >= len(line) || line[i].val != ")" {
+					errorTok(tok, "expected ')'")
+				}
+			}
+			expr = append(expr, newNumberToken(val, tok))
+			continue
+		}
+
+		if tok.kind == tokenKindIdent && tok.val == "__has_include" {
+			if i+1 >= len(line) || line[i+1].val != "(" {
+				errorTok(tok, "expected '('")
+			}
+			j := i + 2
+			for j < len(line) && line[j].val != ")" {
+				j++
+			}
+			if j == len(line) {
+				errorTok(tok, "expected ')'")
+			}
+			name, quoted, n := readHeaderName(tok, line[i+2:j])
+			if n != j-(i+2) {
+				errorTok(line[i+2+n], "expected ')'")
+			}
+			val := 0
+			if searchInclude(name, quoted, tok.file) != "" {
+				val = 1
+			}
+			expr = append(expr, newNumberToken(val, tok))
+			i = j
+			continue
+		}
+
+		expr = append(expr, tok)
+	}
+
+	expr = expandLine(expr, directive)
+
+	// Identifiers left after macro expansion are replaced with 0.
+	for i, tok := range expr {
+		if tok.kind == tokenKindIdent {
+			val := 0
+			if tok.val == "true" {
+				val = 1
+			}
+			expr[i] = newNumberToken(val, tok)
+		}
+	}
+
+	e := &ppExpr{toks: expr, directive: directive}
+	val := e.conditional()
+	if len(e.toks) > 0 {
+		errorTok(e.toks[0], "extra token")
+	}
+	return val.v != 0, rest
+}
+
+// ppValue is a value of a preprocessor expression, which has type intmax_t or uintmax_t.
+type ppValue struct {
+	v          int64
+	isUnsigned bool
+}
+
+type ppExpr struct {
+	toks      []*token
+	directive *token
+	skip      int // nonzero while reading an operand that is not evaluated
+}
+
+func (e *ppExpr) peek() *token {
+	if len(e.toks) == 0 {
+		return nil
+	}
+	return e.toks[0]
+}
+
+func (e *ppExpr) consume(op string) bool {
+	if tok := e.peek(); tok != nil && tok.kind == tokenKindReserved && tok.val == op {
+		e.toks = e.toks[1:]
+		return true
+	}
+	return false
+}
+
+func (e *ppExpr) expect(op string) {
+	if !e.consume(op) {
+		if tok := e.peek(); tok != nil {
+			errorTok(tok, "expected '%s'", op)
+		}
+		errorTok(e.directive, "expected '%s'", op)
+	}
+}
+
+func boolValue(b bool) ppValue {
+	if b {
+		return ppValue{v: 1}
+	}
+	return ppValue{v: 0}
+}
+
+// conditional = logor ("?" expr ":" conditional)?
+func (e *ppExpr) conditional() ppValue {
+	cond := e.logor()
+	if !e.consume("?") {
+		return cond
+	}
+	if cond.v == 0 {
+		e.skip++
+	}
+	then := e.comma()
+	if cond.v == 0 {
+		e.skip--
+	}
+	e.expect(":")
+	if cond.v != 0 {
+		e.skip++
+	}
+	els := e.conditional()
+	if cond.v != 0 {
+		e.skip--
+	}
+	isUnsigned := then.isUnsigned || els.isUnsigned
+	if cond.v != 0 {
+		return ppValue{v: then.v, isUnsigned: isUnsigned}
+	}
+	return ppValue{v: els.v, isUnsigned: isUnsigned}
+}
+
+// comma = conditional ("," conditional)*
+func (e *ppExpr) comma() ppValue {
+	val := e.conditional()
+	for e.consume(",") {
+		val = e.conditional()
+	}
+	return val
+}
+
+// logor = logand ("||" logand)*
+func (e *ppExpr) logor() ppValue {
+	val := e.logand()
+	for e.consume("||") {
+		if val.v != 0 {
+			e.skip++
+			e.logand()
+			e.skip--
+			continue
+		}
+		val = boolValue(e.logand().v != 0)
+	}
+	return val
+}
+
+// logand = binary ("&&" binary)*
+func (e *ppExpr) logand() ppValue {
+	val := e.binary(0)
+	for e.consume("&&") {
+		if val.v == 0 {
+			e.skip++
+			e.binary(0)
+			e.skip--
+			continue
+		}
+		val = boolValue(e.binary(0).v != 0)
+	}
+	return val
+}
+
+// ppBinaryOps lists the binary operators from the lowest precedence.
+var ppBinaryOps = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// binary parses the binary operators of precedence level prec and higher.
+func (e *ppExpr) binary(prec int) ppValue {
+	if prec == len(ppBinaryOps) {
+		return e.unary()
+	}
+
+	val := e.binary(prec + 1)
+	for {
+		tok := e.peek()
+		op := ""
+		for _, o := range ppBinaryOps[prec] {
+			if e.consume(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return val
+		}
+		rhs := e.binary(prec + 1)
+		val = e.apply(tok, op, val, rhs)
+	}
+}
+
+func (e *ppExpr) apply(tok *token, op string, lhs, rhs ppValue) ppValue {
+	// The usual arithmetic conversions, except for shifts.
+	isUnsigned := lhs.isUnsigned || rhs.isUnsigned
+	a, b := lhs.v, rhs.v
+
+	switch op {
+	case "|":
+		return ppValue{a | b, isUnsigned}
+	case "^":
+		return ppValue{a ^ b, isUnsigned}
+	case "&":
+		return ppValue{a & b, isUnsigned}
+	case "==":
+		return boolValue(a == b)
+	case "!=":
+		return boolValue(a != b)
+	case "<":
+		if isUnsigned {
+			return boolValue(uint64(a) < uint64(b))
+		}
+		return boolValue(a < b)
+	case "<=":
+		if isUnsigned {
+			return boolValue(uint64(a) <= uint64(b))
+		}
+		return boolValue(a <= b)
+	case ">":
+		if isUnsigned {
+			return boolValue(uint64(a) > uint64(b))
+		}
+		return boolValue(a > b)
+	case ">=":
+		if isUnsigned {
+			return boolValue(uint64(a) >= uint64(b))
+		}
+		return boolValue(a >= b)
+	case "<<":
+		return ppValue{a << uint64(b&63), lhs.isUnsigned}
+	case ">>":
+		if lhs.isUnsigned {
+			return ppValue{int64(uint64(a) >> uint64(b&63)), true}
+		}
+		return ppValue{a >> uint64(b&63), false}
+	case "+":
+		return ppValue{a + b, isUnsigned}
+	case "-":
+		return ppValue{a - b, isUnsigned}
+	case "*":
+		return ppValue{a * b, isUnsigned}
+	}
+
+	// "/" and "%"
+	if b == 0 {
+		if e.skip > 0 {
+			return ppValue{0, isUnsigned}
+		}
+		errorTok(tok, "division by zero in #%s", e.directive.val)
+	}
+	if isUnsigned {
+		if op == "/" {
+			return ppValue{int64(uint64(a) / uint64(b)), true}
+		}
+		return ppValue{int64(uint64(a) % uint64(b)), true}
+	}
+	if op == "/" {
+		return ppValue{a / b, false}
+	}
+	return ppValue{a % b, false}
+}
+
+// unary = ("+" | "-" | "~" | "!") unary | primary
+func (e *ppExpr) unary() ppValue {
+	switch {
+	case e.consume("+"):
+		return e.unary()
+	case e.consume("-"):
+		val := e.unary()
+		return ppValue{-val.v, val.isUnsigned}
+	case e.consume("~"):
+		val := e.unary()
+		return ppValue{^val.v, val.isUnsigned}
+	case e.consume("!"):
+		return boolValue(e.unary().v == 0)
+	}
+	return e.primary()
+}
+
+// primary = "(" comma ")" | number
+func (e *ppExpr) primary() ppValue {
+	if e.consume("(") {
+		val := e.comma()
+		e.expect(")")
+		return val
+	}
+
+	tok := e.peek()
+	if tok == nil {
+		errorTok(e.directive, "expected an expression")
+	}
+	if tok.kind != tokenKindNumberLiteral {
+		errorTok(tok, "expected an expression")
+	}
+	e.toks = e.toks[1:]
+
+	if tok.err != "" {
+		errorTok(tok, "%s", tok.err)
+	}
+	if tok.ty == nil {
+		tok = copyToken(tok)
+		convertNumber(tok)
+	}
+	if tok.ty.isFlonum() {
+		errorTok(tok, "floating constant in preprocessor expression")
+	}
+	return ppValue{int64(tok.num), tok.ty.isUnsigned}
+}
@@ -8,18 +8,30 @@ int sub(int x, int y) { return x-y; }
 int add6(int a, int b, int c, int d, int e, int f) {
   return a+b+c+d+e+f;
 }
+float add_float(float x, float y) { return x+y; }
+double add_double(double x, double y) { return x+y; }
+double add_mixed(int a, double x, int b, float y) { return a+x+b+y; }
+int int_of_double(double x) { return x; }
+short add_short(short a, short b) { return a+b; }
+long add_long(long a, long b) { return a+b; }
+char ret_char_neg() { return -3; }
+struct layout { char a; int b; short c; long d; char e; int f[3]; };
+int layout_size() { return sizeof(struct layout); }
+long layout_d(struct layout *p) { return p->d; }
+int layout_f2(struct layout *p) { return p->f[2]; }
+__asm__(".text\n.globl ret_bool_dirty\nret_bool_dirty:\n\tmovl \$0x1201, %eax\n\tret\n");
 EOF
 
 assert() {
   expected="$1"
   input="$2"
 
-  echo "$input" > tmp.c && ./cc tmp.c > tmp.s || exit
+  echo "$input" > tmp.c && ./cc "${@:3}" tmp.c > tmp.s || exit
   if [ "$?" != 0 ]; then
     echo "$input => compile failed"
     exit 1
   fi
-  cc -static -o tmp tmp.s tmp2.o
+  cc -static -o tmp tmp.s tmp2.o -lm
   ./tmp
   actual="$?"
 
@@ -31,15 +43,861 @@ assert() {
   fi
 }
 
+assert_error() {
+  expected="$1"
+  input="$2"
+
+  echo "$input" > tmp.c
+  if ./cc "${@:3}" tmp.c > tmp.s 2> tmp.err; then
+    echo "$input => compile succeeded, but an error was expected"
+    exit 1
+  fi
+
+  if grep -qF -- "$expected" tmp.err; then
+    echo "$input => $expected" "OK!"
+  else
+    echo "$input => $expected expected, but got:"
+    cat tmp.err
+    exit 1
+  fi
+}
+
+assert_pp() {
+  expected="$1"
+  input="$2"
+
+  echo "$input" > tmp.c
+  actual="$(./cc -E "${@:3}" tmp.c)" || exit
+  if [ "$actual" = "$expected" ]; then
+    echo "$input => $actual" "OK!"
+  else
+    echo "$input => $expected expected, but got $actual"
+    exit 1
+  fi
+}
+
+assert 3 'struct node { int val; struct node *next; }; int main() { struct node a; struct node b; a.val = 1; a.next = &b; b.val = 2; b.next = 0; return a.val + a.next->val; }'
+assert 6 'struct node { int val; struct node *next; }; int sum(struct node *n) { int s = 0; for (; n; n = n->next) s = s + n->val; return s; } int main() { struct node a, b, c; a.val = 1; a.next = &b; b.val = 2; b.next = &c; c.val = 3; c.next = 0; return sum(&a); }'
+assert 16 'struct node { struct node *prev; struct node *next; }; int main() { return sizeof(struct node); }'
+assert 8 'struct s; struct s *p; struct s { int a; int b; }; int main() { return sizeof(*p); }'
+assert 2 'struct s; int get(struct s *p); struct s { int a; int b; }; int get(struct s *p) { return p->b; } int main() { struct s x; x.b = 2; return get(&x); }'
+assert 4 'typedef struct node node_t; struct node { int val; node_t *next; }; int main() { node_t n; n.val = 4; n.next = &n; return n.next->val; }'
+assert 8 'struct t *p; int main() { return sizeof(p); }'
+assert 12 'struct a { struct b *pb; int x; }; struct b { struct a *pa; int y; }; int main() { struct a a; struct b b; a.pb = &b; b.pa = &a; a.x = 5; b.y = 7; return a.pb->pa->x + a.pb->y; }'
+assert 8 'union u; union u *p; union u { int a; long b; }; int main() { return sizeof(*p); }'
+assert 2 'struct t { int a; }; int main() { struct t { int a; int b; }; return sizeof(struct t) / 4; }'
+assert 1 'struct t { int a; }; int main() { struct t x; { struct t { char c[5]; } y; } x.a = 1; return sizeof(x) / 4; }'
+assert 8 'struct t { int a; int b; }; int main() { struct t; struct t *p; struct t { long x; }; return sizeof(*p); }'
+assert 8 'struct t { int a; int b; }; int main() { struct t *p; { struct t { char c; } y; } return sizeof(*p); }'
+assert_error "invalid application of 'sizeof' to an incomplete type 'struct s'" 'struct s; int main() { return sizeof(struct s); }'
+assert_error "invalid application of 'sizeof' to an incomplete type 'struct s'" 'struct s *p; int main() { return sizeof(*p); }'
+assert_error "variable has incomplete type 'struct s'" 'struct s; int main() { struct s x; return 0; }'
+assert_error "variable has incomplete type 'struct s'" 'typedef struct s S; int main() { S x; return 0; }'
+assert_error "variable has incomplete type 'struct a'" 'struct a { struct a x; };'
+assert_error "member access into incomplete type 'struct s'" 'struct s *p; int main() { return p->a; }'
+assert_error "array has incomplete element type 'struct S'" 'struct S; struct S a[3];'
+assert_error "array has incomplete element type 'union u'" 'union u; int main() { union u (*p)[2]; return 0; }'
+assert_error "array has incomplete element type 'void'" 'void a[3];'
+assert_error "arithmetic on a pointer to an incomplete type 'struct s'" 'struct s *p; int main() { p = p + 1; return 0; }'
+assert_error "arithmetic on a pointer to an incomplete type 'struct s'" 'struct s *p, *q; int main() { return p - q; }'
+assert_error 'arithmetic on a pointer to an array without a length' 'int (*p)[]; int main() { p = p + 1; return 0; }'
+assert 16 'struct s *p; struct s { int a; long b; }; int main() { return (char *)(p + 1) - (char *)p; }'
+assert_error "redefinition of 'struct s'" 'struct s { int a; }; struct s { int b; };'
+assert_error 'not a union tag' 'struct s; union s { int a; };'
+
+assert 4 'int main() { return sizeof(int); }'
+assert 1 'int main() { return sizeof(char); }'
+assert 8 'int main() { return sizeof(int *); }'
+assert 12 'int main() { return sizeof(int[3]); }'
+assert 80 'int main() { return sizeof(int *[10]); }'
+assert 8 'int main() { return sizeof(int (*)[10]); }'
+assert 40 'int main() { int (*a)[10]; return sizeof(*a); }'
+assert 24 'int main() { return sizeof(char *(*[3])(void)); }'
+assert 8 'int main() { return sizeof(int (*)(int, char *)); }'
+assert 8 'int main() { return sizeof(long (**)[2]); }'
+assert 4 'typedef int T; int main() { return sizeof(T); }'
+assert 8 'int main() { return sizeof(struct { int a; int b; }); }'
+assert 3 'int main() { int a[2][3]; int (*p)[3] = a; p[1][2] = 3; return a[1][2]; }'
+assert 12 'int main() { int a[3]; return (char*)(&a+1) - (char*)&a; }'
+assert 5 'int main() { int a[3]; int (*p)[3] = &a; (*p)[1] = 5; return a[1] + sizeof(*p) - 12; }'
+assert 6 'char *(*f(void))[3]; char *s[3]; char *(*f(void))[3] { return &s; } int main() { s[1] = "abcdef"; char *(*p)[3] = f(); return (*p)[1][5] - 96; }'
+assert 2 'int sub(int, int); int main() { int (*fp)(int, int) = sub; return fp(5, 3); }'
+assert 3 'int apply(int (*)(int, int), int, int); int add(int x, int y); int apply(int (*f)(int, int), int x, int y) { return f(x, y); } int main() { return apply(add, 1, 2); }'
+assert 5 'int f(int, int b) { return b; } int main() { return f(1, 5); }'
+assert 7 'int f(int a[3]) { return a[1]; } int main() { int x[3]; x[0] = 1; x[1] = 7; x[2] = 3; return f(x); }'
+assert 8 'int f(int a[3]) { return sizeof(a); } int main() { int x[3]; return f(x); }'
+assert 9 'int f(int m[2][3]) { return m[1][2]; } int main() { int x[2][3]; x[1][2] = 9; return f(x); }'
+assert 2 'int main(int argc, char *argv[]) { return argc + (argv[0][0] != 0); }'
+assert 17 'int f(int a[][3]) { return sizeof(a) + a[1][2]; } int main() { int x[2][3]; x[1][2] = 9; return f(x); }'
+assert 8 'int g[]; int main() { g[0] = 4; return sizeof(g) + g[0]; }'
+assert_error "array size missing in 'a'" 'int main() { int a[]; return 0; }'
+assert_error 'array has incomplete element type' 'int a[3][]; int main() { return 0; }'
+assert_error "invalid application of 'sizeof' to an array without a length" 'int main() { return sizeof(int[]); }'
+assert 255 'int main() { return (unsigned char)-1; }'
+assert 1 'int main() { return (char)257; }'
+assert 254 'int main() { return (unsigned char)(short)-2; }'
+assert 3 'int main() { return (int)3.9; }'
+assert 0 'int main() { return (_Bool)0.0; }'
+assert 1 'int main() { long x = (long)1 << 40; return (x >> 40); }'
+assert 4 'int main() { int x = 4; void *p = &x; return *(int *)p; }'
+assert 8 'int main() { return sizeof((char)1 + (long)1); }'
+assert 5 'int main() { int x = 5; (void)x; return x; }'
+assert 4 'typedef struct { int a; int b; } pair_t; int main() { pair_t p; p.b = 4; return ((pair_t *)&p)->b; }'
+assert 8 'int main() { int *a, b; return sizeof(a) + sizeof(b) - 4; }'
+assert 4 'int *a, b; int main() { return sizeof(b); }'
+assert_error 'expected an identifier' 'int main() { int *; return 0; }'
+assert_error 'expected an identifier' 'int main() { int (*)(int); return 0; }'
+
+assert 3 'int add(int x, int y); int main() { int (*fp)(int a, int b) = add; return fp(1, 2); }'
+assert 7 'int add(int x, int y); int main() { int (*fp)(int a, int b) = &add; return (*fp)(3, 4); }'
+assert 2 'int add(int x, int y); int main() { return (*add)(1, 1); }'
+assert 1 'int add(int x, int y); int main() { int (*fp)(int a, int b) = add; return fp == add; }'
+assert 8 'int main() { int (*fp)(int a); return sizeof(fp); }'
+assert 7 'int sub(int x, int y); struct plugin { int id; int (*run)(int x, int y); }; int main() { struct plugin p; p.run = sub; return p.run(10, 3); }'
+assert 1 'int add(int x, int y); int sub(int x, int y); int (*ops[2])(int a, int b); int main() { ops[0] = add; ops[1] = sub; return ops[1](9, 8); }'
+assert 3 'int sub(int x, int y); int apply(int f(int a, int b), int x, int y) { return f(x, y); } int main() { return apply(sub, 5, 2); }'
+assert 5 'int add(int x, int y); int (*pick(int i))(int a, int b) { return add; } int main() { return pick(0)(2, 3); }'
+assert 6 'double half(double x) { return x / 2; } int main() { double (*fp)(double x) = half; return fp(12.5); }'
+assert 4 'char ret(char c) { return c; } int main() { char (*fp)(char c) = ret; return fp(260); }'
+assert 9 'int id(int x) { return x; } int twice(int (*f)(int x), int x) { return f(f(x)); } int main() { return twice(id, 9); }'
+assert 5 'int ret3(); int main() { int ret5(); return ret5(); }'
+assert 3 'int main() { int x = 1; { int ret3(void), y = 2; x = ret3(); } return x; }'
+assert 0 'int main() { int printf(char *fmt, ...); return printf(""); }'
+assert 8 'int main() { int ret5(); int (*fp)() = ret5; return fp() + 3; }'
+assert 7 'int add(int x, int y); int main() { int add = 2; { int add(int x, int y); return add(3, 4); } }'
+assert_error 'called object is not a function or function pointer' 'int main() { int x = 1; return x(); }'
+
+assert 8 'int main() { union { int a; char b[6]; } x; return sizeof(x); }'
+assert 4 'int main() { union { int a; char b[3]; } x; return sizeof(x); }'
+assert 16 'int main() { union { char a; long b; char c[9]; } x; return sizeof(x); }'
+assert 3 'int main() { union { int a; char b[4]; } x; x.a = 515; return x.b[0]; }'
+assert 2 'int main() { union { int a; char b[4]; } x; x.a = 515; return x.b[1]; }'
+assert 0 'int main() { union { int a; char b[4]; } x; x.a = 515; return x.b[2]; }'
+assert 1 'union u { int a; long b; }; int main() { union u x; union u *p = &x; p->b = 1; return x.a; }'
+assert 12 'struct msg { int tag; union { int i; char c; } u; }; int main() { struct msg m; m.tag = 1; m.u.i = 11; return m.tag + m.u.i; }'
+assert 8 'typedef union { int i; long l; } num_t; int main() { num_t n; return sizeof(n); }'
+assert 5 'int main() { union { struct { char a; char b; } s; short h; } x; x.h = 0; x.s.b = 5; return x.h >> 8; }'
+assert_error 'not a union tag' 'struct s { int a; }; int main() { union s x; return 0; }'
+assert_error 'not a struct tag' 'union s { int a; }; int main() { struct s x; return 0; }'
+assert_error "variable has incomplete type 'union nope'" 'int main() { union nope x; return 0; }'
+assert_error 'no such member' 'int main() { union { int a; } x; return x.b; }'
+
+assert 1 'typedef int MyInt, MyInt2[4]; int main() { MyInt x = 1; return x; }'
+assert 16 'typedef int MyInt, MyInt2[4]; int main() { MyInt2 x; return sizeof(x); }'
+assert 1 'int main() { typedef int t; t x = 1; return x; }'
+assert 1 'int main() { typedef struct { int a; } t; t x; x.a = 1; return x.a; }'
+assert 2 'int main() { typedef struct { int a; } t; { typedef int t; } t x; x.a = 2; return x.a; }'
+assert 3 'typedef struct { int a; int b; } pair_t; int sum(pair_t *p) { return p->a + p->b; } int main() { pair_t p; p.a = 1; p.b = 2; return sum(&p); }'
+assert 8 'typedef long size; int main() { size x; return sizeof(x); }'
+assert 8 'typedef int *intp; int main() { int x = 8; intp p = &x; return *p; }'
+assert 3 'typedef int T; int main() { int T = 3; return T; }'
+assert 4 'typedef int T; int main() { T T = 4; return T; }'
+assert 5 'typedef int T; int main() { { int T = 5; } T x = 5; return x; }'
+assert 6 'typedef int T; int f(T T) { return T; } int main() { return f(6); }'
+assert 1 'typedef enum { OFF, ON } state_t; int main() { state_t s = ON; return s; }'
+assert 2 'typedef unsigned char u8; typedef u8 byte; int main() { byte b = 258; return b; }'
+assert_error 'storage class specifier is not allowed in this context' 'int f(typedef int x) { return 0; }'
+assert_error 'unexpected type name' 'typedef int T; int main() { return T; }'
+assert 7 'typedef int T; int main() { long T = 7; return T; }'
+
+assert 0 'int main() { enum { zero, one, two }; return zero; }'
+assert 1 'int main() { enum { zero, one, two }; return one; }'
+assert 2 'int main() { enum { zero, one, two }; return two; }'
+assert 5 'int main() { enum { five=5, six, seven }; return five; }'
+assert 6 'int main() { enum { five=5, six, seven }; return six; }'
+assert 0 'int main() { enum { zero, five=5, three=3, four }; return zero; }'
+assert 5 'int main() { enum { zero, five=5, three=3, four }; return five; }'
+assert 3 'int main() { enum { zero, five=5, three=3, four }; return three; }'
+assert 4 'int main() { enum { zero, five=5, three=3, four }; return four; }'
+assert 4 'int main() { enum { zero, one, two } x; return sizeof(x); }'
+assert 4 'int main() { enum t { zero, one, two }; enum t y; return sizeof(y); }'
+assert 3 'int main() { enum { a = 1 << 1, b = a + 1, }; return b; }'
+assert 2 'int main() { enum { a = 1 << 1, b = a + 1 }; return a; }'
+assert 12 'int main() { enum { N = 3 }; int x[N]; return sizeof(x); }'
+assert 24 'int main() { int x[2 * 3]; return sizeof(x); }'
+assert 2 'enum state { IDLE, RUNNING, DONE };
+enum state next(enum state s) { return s + 1; }
+int main() { return next(RUNNING); }'
+assert 7 'enum { N = 7 }; int main() { return N; }'
+assert 3 'struct pair { int a; int b; }; int main() { struct pair p; p.a = 1; p.b = 2; return p.a + p.b; }'
+assert 1 'int main() { enum { a = 0-1 }; return a < 0; }'
+assert 4 'int main() { enum { N = 4 }; { int N = 5; } return N; }'
+assert 5 'int main() { enum { N = 4 }; { int N = 5; return N; } }'
+assert 3 'int main() { struct t { int a; }; struct t x; x.a = 3; return x.a; }'
+assert 5 'struct u { int a; }; int main() { int p; int q; struct t { int b; }; struct u x; x.a = 5; return x.a; }'
+assert_error 'not a compile-time constant' 'int main() { int n = 3; int x[n]; return 0; }'
+assert_error 'not an lvalue' 'int main() { enum { A }; A = 1; return 0; }'
+assert_error 'unknown enum tag' 'int main() { enum none x; return 0; }'
+assert_error 'not an enum tag' 'int main() { struct s { int a; }; enum s x; return 0; }'
+
+assert 1 'int main() { _Bool x; return sizeof(x); }'
+assert 1 'int main() { _Bool x = 2; return x; }'
+assert 1 'int main() { _Bool x = 256; return x; }'
+assert 0 'int main() { _Bool x = 0; return x; }'
+assert 1 'int main() { _Bool x = 0-1; return x; }'
+assert 1 'int main() { _Bool x = 0.5; return x; }'
+assert 0 'int main() { _Bool x = 0.0; return x; }'
+assert 1 'int main() { int y; _Bool x = &y; return x; }'
+assert 2 'int main() { _Bool x = 3; _Bool y = 4; return x + y; }'
+assert 1 '_Bool f(long x) { return x; } int main() { return f(4294967296); }'
+assert 1 '_Bool ret_bool_dirty(void); int main() { return ret_bool_dirty() == 1; }'
+assert 2 '_Bool ret_bool_dirty(void); int main() { return ret_bool_dirty() + 1; }'
+assert 1 'int g(_Bool b) { return b; } int main() { return g(512); }'
+assert 1 '#include <stdbool.h>
+int main() { bool x = 10; return x == true; }'
+assert 3 '#include <stdbool.h>
+int main() { struct { bool a; bool b; char c; } f; f.a = true; f.b = false; f.c = 2; return sizeof(f) + f.b; }'
+assert 1 '#include <stdbool.h>
+int main() { return __bool_true_false_are_defined + false; }'
+
+assert 3 'void f(int *p) { *p = 3; } int main() { int x; f(&x); return x; }'
+assert 3 'void f(int *p) { *p = 3; return; *p = 5; } int main() { int x; f(&x); return x; }'
+assert 7 'int ret(void) { return 7; } int main(void) { return ret(); }'
+assert 4 'int main() { int x = 4; void *p = &x; int *q = p; return *q; }'
+assert 8 'int main() { void *p; return sizeof(p); }'
+assert 1 'int main() { int x; void *p = &x; return p == &x; }'
+assert 9 'int main() { char x[4]; x[2] = 9; void *p = x; char *q = p + 2; return *q; }' -fvoid-pointer-arith
+assert 3 'int main() { char x[4]; void *p = x; void *q = p + 3; return q - p; }' -fvoid-pointer-arith
+assert_error 'arithmetic on a pointer to void' 'int main() { char x[4]; void *p = x; p = p + 1; return 0; }'
+assert_error 'dereferencing a pointer to void' 'int main() { int x; void *p = &x; return *p; }'
+assert_error "variable has incomplete type 'void'" 'int main() { void x; return 0; }'
+assert_error "variable has incomplete type 'void'" 'int f(void x) { return 0; }'
+assert_error "invalid application of 'sizeof' to a void type" 'void f() {} int main() { return sizeof(f()); }'
+assert_error 'void function should not return a value' 'void f() { return 1; }'
+assert_error 'void value not ignored as it ought to be' 'void f() {} int main() { int x = f(); return x; }'
+assert_error 'wrong number of arguments' 'int f(void); int main() { return f(1); }'
+assert 4 'int sub(); int main() { return sub(5, 2) + sub(1, 1) + 1; }'
+assert 3 'int add(); int main() { int (*fp)() = add; return fp(1, 2); }'
+assert 6 'double add_double(); int main() { float x = 2.5; return add_double(x, 3.5); }'
+assert 5 'int main() { int ret5(); return ret5(); }'
+
+assert 1 'int main() { unsigned char x; return sizeof(x); }'
+assert 2 'int main() { unsigned short int x; return sizeof(x); }'
+assert 4 'int main() { unsigned x; return sizeof(x); }'
+assert 4 'int main() { signed x; return sizeof(x); }'
+assert 8 'int main() { long unsigned int x; return sizeof(x); }'
+assert 8 'int main() { unsigned long long x; return sizeof(x); }'
+assert 1 'int main() { signed char x; return sizeof(x); }'
+assert 1 'int main() { char x = 0-1; return x < 0; }'
+assert 255 'int main() { unsigned char x = 0-1; return x; }'
+assert 1 'int main() { unsigned char x = 255; return x == 255; }'
+assert 1 'int main() { unsigned short x = 65535; return x == 65535; }'
+assert 1 'int main() { short x = 65535; return x == 0-1; }'
+assert 1 'int main() { unsigned x = 0-1; return x == 4294967295; }'
+assert 1 'int main() { unsigned x = 0-1; long y = x; return y == 4294967295; }'
+assert 1 'int main() { int x = 0-1; unsigned long y = x; return y + 1 == 0; }'
+assert 1 'int main() { return 0-1 > 0u; }'
+assert 1 'int main() { int x = 0-1; unsigned y = 1; return x > y; }'
+assert 1 'int main() { int x = 0-1; unsigned char y = 1; return x < y; }'
+assert 1 'int main() { unsigned x = 4294967295; return x / 2 == 2147483647; }'
+assert 1 'int main() { int x = 0-7; return x / 2 == 0-3; }'
+assert 1 'int main() { unsigned x = 0-7; return x % 10 == 9; }'
+assert 1 'int main() { int x = 0-7; return x % 3 == 0-1; }'
+assert 3 'int main() { return 17 % 7; }'
+assert 1 'int main() { unsigned x = 4294967295; x = x + 1; return x == 0; }'
+assert 16 'int main() { return 1 << 4; }'
+assert 1 'int main() { return 1 << 31 < 0; }'
+assert 1 'int main() { return 1u << 31 > 0; }'
+assert 1 'int main() { long x = 1; return x << 40 == 1099511627776; }'
+assert 1 'int main() { int x = 0-16; return x >> 2 == 0-4; }'
+assert 1 'int main() { unsigned x = 0-16; return x >> 2 == 1073741820; }'
+assert 1 'int main() { char x = 0-16; return x >> 2 == 0-4; }'
+assert 1 'int main() { unsigned long x = 0-1; return x >> 63 == 1; }'
+assert 1 'int main() { long x = 0-1; return x >> 63 == 0-1; }'
+assert 1 'int main() { double x = 0-1; unsigned char y = 255; return x < y; }'
+assert 1 'int main() { unsigned long x = 18446744073709551615u; double y = x; return y > 0; }'
+assert 1 'int main() { double x = 17293822569102704640.0; unsigned long y = x; return y == 17293822569102704640u; }'
+assert 1 'int main() { float x = 12345.0f; unsigned long y = x; return y == 12345; }'
+assert_error 'invalid operands' 'int main() { double x; return x % 2; }'
+assert_error 'invalid type' 'int main() { signed unsigned x; return 0; }'
+
+assert 2 'int main() { short x; return sizeof(x); }'
+assert 2 'int main() { short int x; return sizeof(x); }'
+assert 2 'int main() { int short x; return sizeof(x); }'
+assert 4 'int main() { int x; return sizeof(x); }'
+assert 8 'int main() { long x; return sizeof(x); }'
+assert 8 'int main() { long int x; return sizeof(x); }'
+assert 8 'int main() { long long x; return sizeof(x); }'
+assert 8 'int main() { long long int x; return sizeof(x); }'
+assert 8 'int main() { int long long x; return sizeof(x); }'
+assert 8 'int main() { int x; return sizeof(sizeof(x)); }'
+assert 8 'int main() { int *p; int *q; return sizeof(p - q); }'
+assert 1 'int main() { int x = 2147483647; x = x + 1; return x < 0; }'
+assert 1 'int main() { short x = 32767; x = x + 1; return x == 0-32768; }'
+assert 1 'int main() { char x = 127; x = x + 1; return x == 0-128; }'
+assert 1 'int main() { int x = 0-1; long y = x; return y == 0-1; }'
+assert 1 'int main() { long x = 4294967297; int y = x; return y == 1; }'
+assert 1 'int main() { long x = 4294967296 * 3; return x / 4294967296 == 3; }'
+assert 1 'int main() { short x[3]; x[0] = 0-1; x[1] = 2; x[2] = 3; return x[0] + x[1] == 1; }'
+assert 1 'int main() { char x = 255; return x == 0-1; }'
+assert 1 'int main() { return 3000000000 > 2147483647; }'
+assert 7 'short add_short(short a, short b); int main() { return add_short(3, 4); }'
+assert 1 'long add_long(long a, long b); int main() { return add_long(4294967296, 1) == 4294967297; }'
+assert 1 'char ret_char_neg(); int main() { return ret_char_neg() == 0-3; }'
+assert 1 'short sub_short(short a, short b) { return a - b; } int main() { return sub_short(3, 5) == 0-2; }'
+assert 40 'int main() { struct layout { char a; int b; short c; long d; char e; int f[3]; } x; return sizeof(x); }'
+assert 1 'int layout_size(); long layout_d(char *p); int layout_f2(char *p);
+int main() {
+  struct layout { char a; int b; short c; long d; char e; int f[3]; } x;
+  x.d = 7; x.f[2] = 9;
+  return layout_size() == sizeof(x) + layout_d(&x) - 7 + layout_f2(&x) - 9;
+}'
+
+assert 7 'int main() { return sizeof("abc" "def"); }'
+assert 101 'int main() { return ("abc" "def")[4]; }'
+assert 5 '#define FMT "x=" "%d"
+int main() { return sizeof(FMT); }'
+assert 3 '#define STR(x) #x
+int main() { return sizeof(STR(a) "!"); }'
+assert 16 'int main() { return sizeof(L"abc"); }'
+assert 4 'int main() { return sizeof(L"abc"[0]); }'
+assert 98 'int main() { return L"abc"[1]; }'
+assert 8 'int main() { return sizeof(u"abc"); }'
+assert 2 'int main() { return sizeof(u"abc"[0]); }'
+assert 16 'int main() { return sizeof(U"abc"); }'
+assert 3 'int main() { return sizeof("ä"); }'
+assert 3 'int main() { return sizeof(u8"ä"); }'
+assert 8 'int main() { return sizeof(U"ä"); }'
+assert 1 'int main() { return U"ä"[0] == 228; }'
+assert 6 'int main() { return sizeof(u"\U0001F600"); }'
+assert 1 'int main() { return u"\U0001F600"[0] == 55357; }'
+assert 1 'int main() { return u"\U0001F600"[1] == 56832; }'
+assert 1 'int main() { return U"\U0001F600"[0] == 128512; }'
+assert 1 'int main() { return L"\xffff"[0] == 65535; }'
+assert 12 'int main() { return sizeof(L"a" "b"); }'
+assert 12 'int main() { return sizeof("a" L"b"); }'
+assert 3 'int main() { return sizeof(u8"a" "b"); }'
+assert_error 'unsupported non-standard concatenation of string literals' 'int main() { return sizeof(u"a" U"b"); }'
+assert_error 'hex escape sequence out of range' 'int main() { return sizeof(u"\x10000"); }'
+
+assert 3 $'int main() {\r\n  return 3;\r\n}\r\n'
+assert 4 $'int main() {\r  return 4;\r}'
+assert 5 $'\xef\xbb\xbfint main() { return 5; }'
+assert 6 '#define ADD(a, b) \
+  ((a) + \
+   (b))
+int main() { return ADD(2, 4); }'
+assert 7 'int main() { int fo\
+o=7; return foo; }'
+assert 8 'int main() { // a comment \
+return 1;
+  return 8; }'
+assert 4 'int main() {
+  return sizeof("ab\
+c"); }'
+assert 2 'int main() { return \
+  __LINE__; }'
+printf 'int main() { return 9; }' > tmp-nonl.c
+assert 9 '#include "tmp-nonl.c"'
+assert_error 'tmp.c:3:7: ' 'int main() { \
+  return \
+  1 + @; }'
+assert_error 'tmp.c:2:10: ' $'int main() {\r\n  return @; }'
+
+printf '#ifndef TMP_GUARD_H\n#define TMP_GUARD_H\nint guarded() { return 4; }\n#endif\n' > tmp-guard.h
+printf '#pragma once\nint once() { return 7; }\n' > tmp-once.h
+assert 4 '#include "tmp-guard.h"
+#include "tmp-guard.h"
+int main() { return guarded(); }'
+assert 7 '#include "tmp-once.h"
+#include "tmp-once.h"
+int main() { return once(); }'
+assert 4 '#include "tmp-guard.h"
+#undef TMP_GUARD_H
+#define guarded guarded2
+#include "tmp-guard.h"
+int main() { return guarded2(); }'
+printf '#ifndef TMP_GUARD_ELSE_H\n#define TMP_GUARD_ELSE_H\nint a_first;\n#else\nint b_second;\n#endif\n' > tmp-guard-else.h
+printf '#ifndef TMP_GUARD_ELIF_H\n#define TMP_GUARD_ELIF_H\nint c_first;\n#elif 1\nint d_second;\n#endif\n' > tmp-guard-elif.h
+assert 4 '#include "tmp-guard-else.h"
+#include "tmp-guard-else.h"
+int main() { b_second = 4; return b_second; }'
+assert 5 '#include "tmp-guard-elif.h"
+#include "tmp-guard-elif.h"
+int main() { d_second = 5; return d_second; }'
+printf '#include "tmp-self.h"\n' > tmp-self.h
+assert_error '#include nested depth 200 exceeds maximum of 200' '#include "tmp-self.h"'
+assert 3 '#pragma something unknown
+int main() { return 3; }'
+assert 3 '#warning not fatal
+int main() { return 3; }'
+assert_error '#error stop "here"' '#error stop "here"
+int main() { return 3; }'
+assert 100 '#line 100
+int main() { return __LINE__; }'
+assert 10 '#line 10 "grammar.y"
+int main() { return sizeof(__FILE__) - __LINE__ + 10; }'
+assert_error 'grammar.y:21:' '#line 20 "grammar.y"
+int main() {
+  return x; }'
+assert_error 'gen.y:7:' '# 7 "gen.y" 1
+int main() { return x; }'
+assert_error 'requires a simple digit sequence' '#line x'
+
+echo 'int h;' > tmp-pp.h
+assert_pp '# 2 "tmp.c"
+int x = 1+2;' '#define M 1+2
+int x = M;'
+assert_pp '# 1 "tmp.c"
+int f() {
+  if (1)
+
+    return - -1;
+}' 'int f() {
+  if (1)
+
+    return -NEG(1);
+}' '-DNEG(x)=-x'
+assert_pp '# 2 "tmp.c"
+int x = ((1) + (2))
+              ;
+int y;
+# 15 "tmp.c"
+int z;' '#define ADD(a, b) ((a) + (b))
+int x = ADD(1,
+            2);
+int y;
+#if 0
+#endif
+
+
+
+
+
+
+
+
+int z;'
+assert_pp '# 1 "tmp-pp.h"
+int h;
+# 2 "tmp.c"
+int x;' '#include "tmp-pp.h"
+int x;'
+
+assert 2 'int main() {
+  return __LINE__; }'
+assert 3 '#define L __LINE__
+int main() {
+  return L; }'
+assert 6 'int main() { return sizeof(__FILE__); }'
+assert 12 'int main() { return sizeof(__DATE__); }'
+assert 9 'int main() { return sizeof(__TIME__); }'
+assert 1 'int main() { return __COUNTER__ + __COUNTER__ + __COUNTER__ == 3; }'
+assert 1 '#if __STDC__ && __STDC_VERSION__ >= 201112L && __x86_64__ && __LP64__ && __linux__ && __SIZEOF_POINTER__ == 8
+int main() { return 1; }
+#else
+int main() { return 0; }
+#endif'
+assert 3 'int main() { return M; }' -DM=3
+assert 1 'int main() { return M; }' -D M
+assert 4 'int main() { return M(2); }' '-DM(x)=x*2'
+assert 5 'int main() { return 5 M; }' -DM=
+assert 7 '#ifdef M
+int main() { return 1; }
+#else
+int main() { return 7; }
+#endif' -DM -UM
+assert 8 'int main() { return M; }' -UM -DM=8
+assert 1 '#ifndef __x86_64__
+int main() { return 1; }
+#endif' -U__x86_64__
+assert 3 '#if 1
+int main() { return 3; }
+#else
+int main() { return 4; }
+#endif'
+assert 4 '#if 0
+int main() { return 3; }
+#else
+int main() { return 4; }
+#endif'
+assert 5 '#if 0
+#if 1
+int main() { return 3; }
+#endif
+#elif 1 + 1 == 2
+int main() { return 5; }
+#else
+int main() { return 4; }
+#endif'
+assert 6 '#if 1
+int main() { return 6; }
+#elif 1
+int main() { return 7; }
+#endif'
+assert 2 '#define M 2
+#ifdef M
+int main() { return M; }
+#endif'
+assert 1 '#ifndef M
+int main() { return 1; }
+#endif'
+assert 3 '#define M
+#ifndef M
+int main() { return 1; }
+#elifdef M
+int main() { return 3; }
+#endif'
+assert 4 '#ifdef M
+int main() { return 1; }
+#elifndef M
+int main() { return 4; }
+#endif'
+assert 1 '#define M 5
+#if defined M && defined(M) && !defined N && M * 2 == 10
+int main() { return 1; }
+#else
+int main() { return 0; }
+#endif'
+assert 1 '#if (1 << 4 | 3) == 19 && 7 % 4 == 3 && ~0 == -1 && (2 > 1 ? 5 : 6) == 5
+int main() { return 1; }
+#else
+int main() { return 0; }
+#endif'
+assert 1 '#if -1 > 0u && 0x10 == 16 && '"'a'"' == 97 && UNDEFINED == 0
+int main() { return 1; }
+#else
+int main() { return 0; }
+#endif'
+assert 1 '#if 1 || 1 / 0
+int main() { return 1; }
+#endif'
+assert 9 '#if 0
+don'"'"'t @ "unclosed
+#bogus directive
+#if 1
+#else
+#endif
+#endif
+int main() { return 9; }'
+assert 1 '#if __has_include(<stdio.h>) && !__has_include(<no-such-file.h>)
+int main() { return 1; }
+#else
+int main() { return 0; }
+#endif'
+assert 1 '#if defined __has_include
+int main() { return 1; }
+#endif'
+assert_error 'unterminated conditional directive' '#if 1'
+assert_error '#endif without #if' '#endif'
+assert_error '#else after #else' '#if 1
+#else
+#else
+#endif'
+assert_error 'division by zero in #if' '#if 1 / 0
+#endif'
+assert_error 'floating constant in preprocessor expression' '#if 1.5
+#endif'
+assert_error 'no expression' '#if
+#endif'
+
+assert 3 '#define M 3
+int main() { return M; }'
+assert 5 '#define M 3
+#undef M
+#define M 5
+int main() { return M; }'
+assert 4 '#define M 3
+#define M2 M+1
+int main() { return M2; }'
+assert 9 '#define ADD(a, b) ((a) + (b))
+int main() { return ADD(4, 5); }'
+assert 3 '#define ADD(a, b) ((a) + (b))
+int main() { return ADD(1, 2) * ADD((3), 0) - 6; }'
+assert 5 '#define ARRAY_SIZE(x) (sizeof(x) / sizeof((x)[0]))
+int main() { int a[5]; return ARRAY_SIZE(a); }'
+assert 4 '#define F(x) x
+int main() { int y=4; return F(y); }'
+assert 2 '#define F (x) + x
+int main() { int x=1; return F; }'
+assert 7 '#define F() 7
+int main() { return F(); }'
+assert 6 '#define F(x, y) x + y
+int main() { return F(add(1, 2), 3); }'
+assert 3 '#define M(x) x
+int main() { int M=3; return M; }'
+assert 12 '#define dbl(x) (2*(x))
+#define quad(x) dbl(dbl(x))
+int main() { return quad(3); }'
+assert 1 '#define STR(x) #x
+int strcmp(char *a, char *b);
+int main() { return strcmp(STR(a + b), "a + b") == 0; }'
+assert 1 '#define STR(x) #x
+int strcmp(char *a, char *b);
+int main() { return strcmp(STR(  a   +   b  ), "a + b") == 0; }'
+assert 1 '#define STR(x) #x
+int strcmp(char *a, char *b);
+int main() { return strcmp(STR("x\n" '"'\\''"'), "\"x\\n\" '"'\\\\''"'") == 0; }'
+assert 1 '#define str(s) # s
+int strcmp(char *a, char *b);
+int main() { return strcmp(str(: @\n), ": @\n") == 0; }'
+assert 2 '#define STR(x) #x
+#define XSTR(x) STR(x)
+#define M 12
+int strcmp(char *a, char *b);
+int main() { int n = 0; if (strcmp(STR(M), "M") == 0) n = n + 1; if (strcmp(XSTR(M), "12") == 0) n = n + 1; return n; }'
+assert 0 '#define STR(x) #x
+int main() { return STR()[0]; }'
+assert 5 '#define CAT(a, b) a##b
+int main() { int xy=5; return CAT(x, y); }'
+assert 12 '#define CAT(a, b) a ## b
+int main() { return CAT(1, 2); }'
+assert 5 '#define CAT(a, b) a##b
+int main() { int x=5; return CAT(x,) + CAT(,x) - x; }'
+assert 6 '#define CAT(a, b) a##b
+#define M 3
+int main() { int M3=6; return CAT(M, 3); }'
+assert 7 '#define CAT3(a, b, c) a##b##c
+int main() { int abc=7; return CAT3(a, b, c); }'
+assert 8 '#define V(x) var_##x
+int main() { int var_1=8; return V(1); }'
+assert 9 '#define CAT a ## b
+int main() { int ab=9; return CAT; }'
+assert 7 '#define hash_hash # ## #
+#define mkstr(a) # a
+#define in_between(a) mkstr(a)
+#define join(c, d) in_between(c hash_hash d)
+int main() { return sizeof(join(x, y)); }'
+assert 3 '#define F(...) add(__VA_ARGS__)
+int main() { return F(1, 2); }'
+assert 21 '#define F(x, ...) add6(x, __VA_ARGS__)
+int main() { return F(1, 2, 3, 4, 5, 6); }'
+assert 5 '#define F(x, ...) x + sizeof(#__VA_ARGS__)
+int main() { return F(1, a,b); }'
+assert 5 '#define F(args...) add(args)
+int main() { return F(2, 3); }'
+assert 3 '#define F(x, ...) ret3(x, ##__VA_ARGS__)
+int ret3(); int main() { return F(); }'
+assert 8 '#define F(x, ...) add(x, ##__VA_ARGS__)
+int main() { return F(3, 5); }'
+assert 3 '#define F(f, ...) f(__VA_ARGS__)
+int main() { return F(ret3); }'
+assert 8 '#define F(x, ...) (x __VA_OPT__(+ (__VA_ARGS__)))
+int main() { return F(3, 4) + F(1); }'
+assert 4 'int main() { int M=3;
+#define M M + 1
+return M; }'
+assert 4 '#define foo foo
+int main() { int foo=4; return foo; }'
+assert 6 '#define f(a) a*g
+#define g(a) f(a)
+int main() { int x=2; int y=3; int g=1; return f(x)(y); }'
+assert 9 '#define f(x) (x+1)
+#define g(x) f(x)
+int main() { return g(g(7)); }'
+assert 5 '#define F(x) x
+int main() { return F(
+  5
+); }'
+assert_error 'too many arguments to macro' '#define F(x) x
+int main() { return F(1, 2); }'
+assert_error 'unterminated argument list' '#define F(x) x
+int main() { return F(1; }'
+assert_error "'#' is not followed by a macro parameter" '#define F(x) #y
+int main() { return 0; }
+int y = F(1);'
+assert_error 'macro name must be an identifier' '#define 1 2'
+assert_error 'does not give a valid preprocessing token' '#define CAT(a, b) a##b
+int main() { return CAT(+, -); }'
+assert_error 'tmp.c:3:12: ' '#define P(a, b) a##b
+int main() {
+  return P(1, x);
+}'
+assert_error 'tmp.c:2:12: ' 'int main() {
+  return *&__LINE__;
+}'
+
+mkdir -p tmp-inc/dir tmp-inc/quote tmp-inc/sys
+echo 'int inc_three() { return 3; }' > tmp-three.h
+echo 'int inc_four() { return 4; }' > tmp-inc/dir/four.h
+echo '#include "five.h"' > tmp-inc/dir/nested.h
+echo 'int inc_five() { return 5; }' > tmp-inc/dir/five.h
+echo 'int inc_six() { return 6; }' > tmp-inc/quote/six.h
+echo 'int inc_seven() { return 7; }' > tmp-inc/sys/seven.h
+printf 'int inc_bad() {\n  return x;\n}\n' > tmp-inc/dir/bad.h
+
+assert 3 '#include "tmp-three.h"
+int main() { return inc_three(); }'
+assert 4 '#include <four.h>
+int main() { return inc_four(); }' -Itmp-inc/dir
+assert 4 '#include "four.h"
+int main() { return inc_four(); }' -I tmp-inc/dir
+assert 5 '#include <nested.h>
+int main() { return inc_five(); }' -Itmp-inc/dir
+assert 6 '#include "six.h"
+int main() { return inc_six(); }' -iquote tmp-inc/quote
+assert 7 '#include <seven.h>
+int main() { return inc_seven(); }' -isystem tmp-inc/sys
+assert 7 '# include <dir/four.h>
+#
+#include "sys/seven.h"
+int main() { return inc_seven() + inc_four() - 4; }' -Itmp-inc
+assert_error 'six.h: file not found' '#include <six.h>
+int main() { return 0; }' -iquote tmp-inc/quote
+assert_error 'tmp.c:1:10: ' '#include "none.h"'
+assert_error 'tmp-inc/dir/bad.h:2:10: ' '#include "bad.h"
+int main() { return inc_bad(); }' -Itmp-inc/dir
+assert 4 '#define HDR "four.h"
+#include HDR
+int main() { return inc_four(); }' -Itmp-inc/dir
+assert 4 '#define HDR <four.h>
+#include HDR
+int main() { return inc_four(); }' -Itmp-inc/dir
+assert_error 'invalid preprocessor directive' '#foo'
+
+assert_error 'tmp.c:1:25: ' 'int main() { return 1 + ; }'
+assert_error 'expected an expression' 'int main() { return 1 + ; }'
+assert_error 'tmp.c:1:21: ' 'int main() { return x; }'
+assert_error 'undefined variable' 'int main() { return x; }'
+assert_error 'tmp.c:2:3: ' 'int main() {
+  1 = 2;
+}'
+assert_error 'not an lvalue' 'int main() { 1 = 2; }'
+assert_error 'expected '"';'" 'int main() { return 1 }'
+assert_error 'not a struct' 'int main() { int a; return a.b; }'
+assert_error 'no such member' 'int main() { struct {int a;} x; return x.b; }'
+assert_error 'invalid operands' 'int main() { int *a; int *b; return a+b; }'
+
+assert 35 'int main() { float x=3.5; return x*10; }'
+assert 7 'int main() { double x=3.5; return x*2; }'
+assert 3 'int main() { double x=3.99; return x; }'
+assert 1 'int main() { return 0.1+0.2 != 0.3; }'
+assert 1 'int main() { float x=0.1; double y=0.1; return x != y; }'
+assert 1 'int main() { return 0.5 < 1; }'
+assert 0 'int main() { return 1.0 < 1; }'
+assert 1 'int main() { return 1.0 <= 1; }'
+assert 1 'int main() { return 2 > 1.5f; }'
+assert 1 'int main() { return 1.5 == 3/2.0; }'
+assert 0 'int main() { return 3/2 == 1.5; }'
+assert 5 'int main() { return .5*10; }'
+assert 50 'int main() { return 5e1; }'
+assert 12 'int main() { return 0x1.8p3; }'
+assert 2 'int main() { return 2.; }'
+assert 4 'int main() { return sizeof(1.0f); }'
+assert 8 'int main() { return sizeof(1.0); }'
+assert 4 'int main() { float x; return sizeof(x); }'
+assert 8 'int main() { double x; return sizeof(x); }'
+assert 5 'int main() { double x=2.5; if (x) return 5; return 3; }'
+assert 3 'int main() { double x=0.0; if (x) return 5; return 3; }'
+assert 6 'int main() { double x=0; for (x=0.5; x<3; x=x+0.5) 0; return x*2; }'
+assert 8 'double f(double a, double b, double c, double d, double e, double f, double g, double h, int i) { return h; } int main() { return f(1, 2, 3, 4, 5, 6, 7, 8, 9); }'
+assert_error 'too many parameters' 'int f(int a, int b, int c, int d, int e, int f2, int g) { return g; } int main() { return 0; }'
+assert_error 'too many parameters' 'double f(double a, double b, double c, double d, double e, double f, double g, double h, double i) { return i; } int main() { return 0; }'
+assert 1 'int main() { double z=0.0; double n=z/z; if (n) return 1; return 0; }'
+assert 2 'int main() { float z=0.0; float n=z/z; if (n) return 2; else return 3; }'
+assert 1 'int main() { double z=0.0; double n=z/z; while (n) return 1; return 0; }'
+assert 1 'int main() { double z=0.0; double n=z/z; for (;n;) return 1; return 0; }'
+assert 0 'int main() { float x=0.0; while (x) return 1; return 0; }'
+assert 10 'int main() { double x[2]; x[0]=2.5; x[1]=7.5; return x[0]+x[1]; }'
+assert 7 'int main() { struct {char c; float f; double d;} s; s.f=2.5; s.d=4.5; return s.f+s.d; }'
+assert 6 'float add_float(float x, float y); int main() { return add_float(2.5, 3.5); }'
+assert 6 'double add_double(double x, double y); int main() { return add_double(2.5, 3.5); }'
+assert 10 'double add_mixed(int a, double x, int b, float y); int main() { return add_mixed(1, 2.5, 3, 3.5); }'
+assert 9 'int int_of_double(double x); int main() { return int_of_double(9); }'
+assert 4 'double div2(double x) { return x/2; } int main() { return div2(9); }'
+assert 6 'float fadd(float x, int y, double z) { return x+y+z; } int main() { return fadd(1.5, 2, 2.5); }'
+assert 7 'double sqrt(double x); int main() { return sqrt(49); }'
+assert 3 'int sprintf(char *buf, char *fmt, ...); int main() { char b[10]; return sprintf(b, "%.1f", 1.25); }'
+assert 49 'int sprintf(char *buf, char *fmt, ...); int main() { char b[10]; sprintf(b, "%.0f", 1.25f); return b[0]; }'
+assert_error 'wrong number of arguments' 'double sqrt(double x); int main() { return sqrt(); }'
+assert_error 'invalid floating constant' 'int main() { return 1.0e; }'
+
+assert 31 'int main() { return 0x1F; }'
+assert 31 'int main() { return 0X1f; }'
+assert 171 'int main() { return 0xAb; }'
+assert 15 'int main() { return 017; }'
+assert 0 'int main() { return 0; }'
+assert 5 'int main() { return 0b101; }'
+assert 5 'int main() { return 0B101; }'
+assert 10 'int main() { return 10u; }'
+assert 1 'int main() { return 1UL; }'
+assert 3 'int main() { return 3lu + 0LL + 0ull + 0LLU; }'
+assert 16 'int main() { return 0x100000000 / 0x10000000; }'
+assert 1 'int main() { return 0xffffffffffffffff == 0-1; }'
+assert 1 'int main() { return 18446744073709551615u == 0-1; }'
+assert 8 'int main() { return sizeof(2147483648); }'
+assert 8 'int main() { return sizeof(0xffffffffffffffff); }'
+assert_error 'invalid integer constant' 'int main() { return 08; }'
+assert_error 'invalid integer constant' 'int main() { return 1lul; }'
+assert_error 'invalid integer constant' 'int main() { return 1lL; }'
+assert_error 'invalid integer constant' 'int main() { return 0x; }'
+assert_error 'invalid integer constant' 'int main() { return 12abc; }'
+assert_error 'integer constant is too large' 'int main() { return 18446744073709551616; }'
+
+assert 7 'int main() { return "\a"[0]; }'
+assert 8 'int main() { return "\b"[0]; }'
+assert 9 'int main() { return "\t"[0]; }'
+assert 10 'int main() { return "\n"[0]; }'
+assert 11 'int main() { return "\v"[0]; }'
+assert 12 'int main() { return "\f"[0]; }'
+assert 13 'int main() { return "\r"[0]; }'
+assert 27 'int main() { return "\e"[0]; }'
+assert 34 'int main() { return "a\"b"[1]; }'
+assert 4 'int main() { return sizeof("a\"b"); }'
+assert 92 'int main() { return "\\"[0]; }'
+assert 63 'int main() { return "\?"[0]; }'
+assert 0 'int main() { return "\0"[0]; }'
+assert 16 'int main() { return "\20"[0]; }'
+assert 65 'int main() { return "\101"[0]; }'
+assert 49 'int main() { return "\1011"[1]; }'
+assert 104 'int main() { return "\x68"[0]; }'
+assert 171 'int main() { return "\xAB"[0]; }'
+assert 195 'int main() { return "\u00e9"[0]; }'
+assert 169 'int main() { return "\u00e9"[1]; }'
+assert 5 'int main() { return sizeof("\U0001F600"); }'
+assert_error 'hex escape sequence out of range' 'int main() { return "\x100"[0]; }'
+assert_error 'unclosed string literal' 'int main() { return "abc; }'
+
+assert 97 'int main() { return '"'a'"'; }'
+assert 10 'int main() { return '"'\\n'"'; }'
+assert 0 'int main() { return '"'\\0'"'; }'
+assert 65 'int main() { return '"'\\x41'"'; }'
+assert 65 'int main() { return '"'\\101'"'; }'
+assert 39 'int main() { return '"'\\''"'; }'
+assert 1 'int main() { return '"'\\xff'"' == 0-1; }'
+assert 233 'int main() { return '"'\\u00e9'"' - 256 + 256; }'
+assert 4 'int main() { return sizeof('"'a'"'); }'
+assert_error 'unclosed character constant' 'int main() { return '"'ab'"'; }'
+
+assert 3 'int main() { int MAX=3; return MAX; }'
+assert 7 'int main() { int Foo=3; int fOO=4; return Foo+fOO; }'
+assert 5 'int main() { int _start=5; return _start; }'
+assert 6 'int main() { int __x_1=2; int X_=4; return __x_1+X_; }'
+assert 3 'int main() { return Ret3(); } int Ret3() { return 3; }'
+assert 4 'int main() { struct Node {int Val;} n; n.Val=4; return n.Val; }'
+assert 9 'int main() { int $a=4; int b$=5; return $a+b$; }' -fdollars-in-identifiers
+assert_error 'unexpected character: $' 'int main() { int $a=4; return $a; }'
+
+assert_error 'tmp.c:1:23: ' 'int main() { int x; x <<= 1; }'
+assert_error 'expected '"';'" 'int main() { int x; x <<= 1; }'
+assert_error 'tmp.c:1:23: ' 'int main() { int x; x %= 1 ^ 2 | ~3 ? 4 : 5; }'
+assert_error 'tmp.c:1:21: ' 'int main() { return ...; }'
+assert_error 'expected an expression' 'int main() { return ...; }'
+
+assert 3 $'int main() {\r\n\treturn 3;\r\n}'
+assert 3 $'int main()\t{\v\freturn\t3; }'
+assert 2 'int main() { /* return 1; */ return 2; }'
+assert 2 'int main() { // return 1;
+  return 2; }'
+assert 2 'int main() { /* multi
+  line
+  comment */ return 2; } // trailing'
+assert 4 'int main() { int a = 8 /* / */ / 2; return a; }'
+assert_error 'tmp.c:3:14: ' 'int main() { /*
+  */
+  return 1 + ; }'
+assert_error 'tmp.c:2:9: ' $'int main() {\r\n\treturn x;\r\n}'
+assert_error 'unclosed block comment' 'int main() { /* return 1; }'
+
 assert 3 'int main() { struct t {int a;} x; struct t *y = &x; x.a=3; return y->a; }'
 assert 3 'int main() { struct t {int a;} x; struct t *y = &x; y->a=3; return x.a; }'
 
-assert 16 'int main() { struct t {int a; int b;} x; struct t y; return sizeof(y); }'
+assert 8 'int main() { struct t {int a; int b;} x; struct t y; return sizeof(y); }'
 
-assert 16 'int main() { struct {char a; int b;} x; return sizeof(x); }'
-assert 16 'int main() { struct {int a; char b;} x; return sizeof(x); }'
+assert 8 'int main() { struct {char a; int b;} x; return sizeof(x); }'
+assert 8 'int main() { struct {int a; char b;} x; return sizeof(x); }'
 
-assert 15 'int main() { int x; int y; char z; char *a=&y; char *b=&z; return b-a; }'
+assert 7 'int main() { int x; int y; char z; char *a=&y; char *b=&z; return b-a; }'
 assert 1 'int main() { int x; char y; int z; char *a=&y; char *b=&z; return b-a; }'
 
 assert 1 'int main() { struct {int a; int b;} x; x.a=1; x.b=2; return x.a; }'
@@ -97,6 +955,9 @@ assert 0 'int main() { return 0==1; }'
 assert 1 'int main() { return 42==42; }'
 assert 1 'int main() { return 0!=1; }'
 assert 0 'int main() { return 42!=42; }'
+assert 2 'int main() { return (1==1) + (2!=3); }'
+assert 3 'int main() { return (1<2) * 3; }'
+assert 8 'int main() { return sizeof(1 == 1) + sizeof(1 < 2); }'
 
 assert 3 'int main() { int a=3; return a; }'
 assert 8 'int main() { int a=3; int z=5; return a+z; }'
@@ -130,11 +991,11 @@ assert 66 'int main() { return add6(1,2,add6(3,4,5,6,7,8),9,10,11); }'
 assert 136 'int main() { return add6(1,2,add6(3,add6(4,5,6,7,8,9),10,11,12,13),14,15,16); }'
 
 assert 3 'int main() { int x=3; return *&x; }'
-assert 3 'int main() { int x=3; int y=&x; int z=&y; return **z; }'
+assert 3 'int main() { int x=3; int *y=&x; int **z=&y; return **z; }'
 assert 5 'int main() { int x=3; int y=5; return *(&x+1); }'
 assert 3 'int main() { int x=3; int y=5; return *(&y-1); }'
 assert 5 'int main() { int x=3; int y=5; return *(&x-(-1)); }'
-assert 5 'int main() { int x=3; int y=&x; *y=5; return x; }'
+assert 5 'int main() { int x=3; int *y=&x; *y=5; return x; }'
 assert 7 'int main() { int x=3; int y=5; *(&x+1)=7; return y; }'
 assert 7 'int main() { int x=3; int y=5; *(&y-2+1)=7; return x; }'
 assert 5 'int main() { int x=3; return (&x+2)-&x+3; }'
@@ -178,17 +1039,17 @@ assert 3 'int main() { int x[2][3]; int *y=x; y[3]=3; return x[1][0]; }'
 assert 4 'int main() { int x[2][3]; int *y=x; y[4]=4; return x[1][1]; }'
 assert 5 'int main() { int x[2][3]; int *y=x; y[5]=5; return x[1][2]; }'
 
-assert 8 'int main() { int x; return sizeof(x); }'
-assert 8 'int main() { int x; return sizeof x; }'
+assert 4 'int main() { int x; return sizeof(x); }'
+assert 4 'int main() { int x; return sizeof x; }'
 assert 8 'int main() { int *x; return sizeof(x); }'
-assert 32 'int main() { int x[4]; return sizeof(x); }'
-assert 96 'int main() { int x[3][4]; return sizeof(x); }'
-assert 32 'int main() { int x[3][4]; return sizeof(*x); }'
-assert 8 'int main() { int x[3][4]; return sizeof(**x); }'
-assert 9 'int main() { int x[3][4]; return sizeof(**x) + 1; }'
-assert 9 'int main() { int x[3][4]; return sizeof **x + 1; }'
-assert 8 'int main() { int x[3][4]; return sizeof(**x + 1); }'
-assert 8 'int main() { int x=1; return sizeof(x=2); }'
+assert 16 'int main() { int x[4]; return sizeof(x); }'
+assert 48 'int main() { int x[3][4]; return sizeof(x); }'
+assert 16 'int main() { int x[3][4]; return sizeof(*x); }'
+assert 4 'int main() { int x[3][4]; return sizeof(**x); }'
+assert 5 'int main() { int x[3][4]; return sizeof(**x) + 1; }'
+assert 5 'int main() { int x[3][4]; return sizeof **x + 1; }'
+assert 4 'int main() { int x[3][4]; return sizeof(**x + 1); }'
+assert 4 'int main() { int x=1; return sizeof(x=2); }'
 assert 1 'int main() { int x=1; sizeof(x=2); return x; }'
 
 assert 0 'int x; int main() { return x; }'
@@ -200,8 +1061,8 @@ assert 1 'int x[4]; int main() { x[0]=0; x[1]=1; x[2]=2; x[3]=3; return x[1]; }'
 assert 2 'int x[4]; int main() { x[0]=0; x[1]=1; x[2]=2; x[3]=3; return x[2]; }'
 assert 3 'int x[4]; int main() { x[0]=0; x[1]=1; x[2]=2; x[3]=3; return x[3]; }'
 
-assert 8 'int x; int main() { return sizeof(x); }'
-assert 32 'int x[4]; int main() { return sizeof(x); }'
+assert 4 'int x; int main() { return sizeof(x); }'
+assert 16 'int x[4]; int main() { return sizeof(x); }'
 
 assert 1 'int main() { char x=1; return x; }'
 assert 1 'int main() { char x=1; char y=2; return x; }'
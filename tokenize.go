@@ -1,20 +1,106 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-var userIn string
 var in string
-var currentFilename string
+var currentFile *file
+
+type file struct {
+	name     string
+	contents string // the text after translation phases 1 and 2
+	source   string // the text with its lines as the user sees them
+	splices  []splice
+
+	includeDepth int // the number of #include directives this file is nested in
+}
+
+// splice records that the contents from pos on are delta bytes behind the
+// source, because of the backslash-newlines removed before pos.
+type splice struct {
+	pos   int
+	delta int
+}
+
+// newFile makes a file of src through translation phases 1 and 2: a UTF-8
+// BOM is removed, "\r\n" and "\r" become "\n", and backslash-newlines are
+// deleted to join lines. The contents always end with a newline.
+func newFile(name, src string) *file {
+	src = strings.TrimPrefix(src, "\uFEFF")
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	if src != "" && !strings.HasSuffix(src, "\n") {
+		src += "\n"
+	}
+
+	f := &file{name: name, source: src}
+	var sb strings.Builder
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\\' && i+1 < len(src) && src[i+1] == '\n' {
+			i++
+			f.splices = append(f.splices, splice{pos: sb.Len(), delta: len(src[:i+1]) - sb.Len()})
+			continue
+		}
+		sb.WriteByte(src[i])
+	}
+	f.contents = sb.String()
+
+	// A backslash-newline may have joined the last line with nothing.
+	if f.contents != "" && !strings.HasSuffix(f.contents, "\n") {
+		f.contents += "\n"
+	}
+	return f
+}
+
+// sourcePos maps an offset in the contents to the offset in the source.
+func (f *file) sourcePos(pos int) int {
+	i := sort.Search(len(f.splices), func(i int) bool { return f.splices[i].pos > pos })
+	if i > 0 {
+		pos += f.splices[i-1].delta
+	}
+	if pos > len(f.source) {
+		pos = len(f.source)
+	}
+	return pos
+}
 
 type token struct {
 	kind tokenKind
 	val  string
 	num  int
-	str  string
+	fval float64
+	ty   *typ // type of a number literal
+
+	chars []strChar // decoded contents of a string literal
+
+	// source location
+	file   *file
+	offset int
+	line   int
+	col    int
+
+	// The file name and line number as set by #line.
+	filename  string
+	lineDelta int
+
+	atBOL    bool // first token on a line
+	hasSpace bool // preceded by whitespace
+
+	hideset []string // macros that must not be expanded again
+	origin  *token   // the macro invocation this token was expanded from
+
+	// err is a lexical error in the token. It is reported only if the token
+	// is not skipped by the preprocessor.
+	err string
 }
 
 type tokenKind int
@@ -25,140 +111,624 @@ const (
 	tokenKindStringLiteral
 	tokenKindIdent
 	tokenKindType
+	tokenKindEOF
 )
 
-func tokenizeFile(filename string) {
+func tokenizeFile(filename string) []*token {
 
 	b, err := os.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		_, _ = fmt.Fprintf(os.Stderr, "cannot open %s: %v\n", filename, err)
+		os.Exit(1)
 	}
 
-	userIn = string(b)
-	in = string(b)
-	currentFilename = filename
+	return tokenize(newFile(filename, string(b)))
+}
 
-	tokenize()
+// newToken creates a token of the given kind starting at the current position
+// and consumes n bytes of the input.
+func newToken(kind tokenKind, n int) *token {
+	tok := &token{kind: kind, val: in[:n], file: currentFile, offset: len(currentFile.contents) - len(in)}
+	in = in[n:]
+	return tok
 }
 
-func tokenize() {
+// addLineNumbers sets the line and column of toks in the source, where the
+// lines joined by backslash-newlines are still apart.
+func addLineNumbers(toks []*token) {
+	line, lineStart, pos := 1, 0, 0
+	for _, tok := range toks {
+		offset := tok.file.sourcePos(tok.offset)
+		for ; pos < offset; pos++ {
+			if tok.file.source[pos] == '\n' {
+				line++
+				lineStart = pos + 1
+			}
+		}
+		tok.line = line
+		tok.col = offset - lineStart + 1
+	}
+}
+
+// tokenize splits the contents of f into preprocessing tokens. Keywords and
+// number literals are converted later, after preprocessing.
+func tokenize(f *file) []*token {
+	currentFile = f
+	in = f.contents
+
+	var toks []*token
+	atBOL, hasSpace := true, false
 	for len(in) > 0 {
 
-		if in[0] == '"' {
-			tokens = append(tokens, toString())
+		// Skip line comments.
+		if strings.HasPrefix(in, "//") {
+			n := strings.IndexByte(in, '\n')
+			if n < 0 {
+				n = len(in)
+			}
+			in = in[n:]
+			hasSpace = true
 			continue
 		}
 
-		if in[0] == ' ' || in[0] == '\n' {
+		// Skip block comments.
+		if strings.HasPrefix(in, "/*") {
+			n := strings.Index(in[2:], "*/")
+			if n < 0 {
+				errorAt("unclosed block comment")
+			}
+			in = in[n+4:]
+			hasSpace = true
+			continue
+		}
+
+		if in[0] == '\n' {
 			in = in[1:]
+			atBOL, hasSpace = true, false
 			continue
 		}
 
-		if in[0] >= 'a' && in[0] <= 'z' {
-			name := in[0:1]
+		if isSpace(in[0]) {
 			in = in[1:]
-			for len(in) > 0 && (isAlpha() || isDigit()) {
-				name += in[0:1]
-				in = in[1:]
-			}
-			tokens = append(tokens, identifierToken(name))
+			hasSpace = true
 			continue
 		}
 
-		if strings.Contains("+-*/()<>=!;{},&[].", string(in[0])) {
-			if len(in) > 1 && (in[0:2] == "<=" || in[0:2] == ">=" || in[0:2] == "==" || in[0:2] == "!=" || in[0:2] == "->") {
-				tokens = append(tokens, &token{kind: tokenKindReserved, val: in[0:2]})
-				in = in[2:]
-			} else {
-				tokens = append(tokens, &token{kind: tokenKindReserved, val: string(in[0])})
-				in = in[1:]
+		var tok *token
+		if n := stringPrefixLen(); n >= 0 {
+			tok = toString(n)
+		} else if in[0] == '\'' {
+			tok = toChar()
+		} else if isIdentStart(in[0]) {
+			n := 1
+			for n < len(in) && (isIdentStart(in[n]) || isDigit(in[n])) {
+				n++
 			}
-			continue
+			tok = newToken(tokenKindIdent, n)
+		} else if isDigit(in[0]) || (in[0] == '.' && len(in) > 1 && isDigit(in[1])) {
+			tok = toNumber()
+		} else if n := punctuatorLen(); n > 0 {
+			tok = newToken(tokenKindReserved, n)
+		} else {
+			_, n := utf8.DecodeRuneInString(in)
+			tok = newToken(tokenKindReserved, n)
+			tok.err = "unexpected character: " + tok.val
 		}
 
-		if isDigit() {
-			n := toInt()
-			tokens = append(tokens, &token{kind: tokenKindNumberLiteral, num: n})
-			continue
+		tok.atBOL, tok.hasSpace = atBOL, hasSpace
+		atBOL, hasSpace = false, false
+		toks = append(toks, tok)
+	}
+
+	eof := newToken(tokenKindEOF, 0)
+	eof.atBOL = true
+	toks = append(toks, eof)
+	addLineNumbers(toks)
+	return toks
+}
+
+// punctuators is ordered so that longer punctuators are matched first.
+var punctuators = []string{
+	"<<=", ">>=", "...",
+	"==", "!=", "<=", ">=", "->", "++", "--", "<<", ">>", "&&", "||",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "##",
+	"+", "-", "*", "/", "%", "(", ")", "<", ">", "=", "!", ";", "{", "}",
+	",", "&", "|", "^", "~", "?", ":", "[", "]", ".", "#",
+}
+
+// punctuatorLen returns the length of the longest punctuator at the current position, or 0.
+func punctuatorLen() int {
+	for _, p := range punctuators {
+		if strings.HasPrefix(in, p) {
+			return len(p)
 		}
+	}
+	return 0
+}
 
-		errorAt("unexpected character: " + string(in[0]))
+// lexErr is the first lexical error found in the token being read.
+var lexErr string
+
+func lexError(msg string) {
+	if lexErr == "" {
+		lexErr = msg
 	}
 }
 
+// displayName returns the file name of tok for diagnostics and __FILE__.
+func (tok *token) displayName() string {
+	if tok.filename != "" {
+		return tok.filename
+	}
+	return tok.file.name
+}
+
+// displayLine returns the line number of tok for diagnostics and __LINE__.
+func (tok *token) displayLine() int {
+	return tok.line + tok.lineDelta
+}
+
+// errorAt reports an error at the current position of the tokenizer and exits.
+func errorAt(msg string) {
+	verrorAt(currentFile, len(currentFile.contents)-len(in), currentFile.name, 0, msg)
+	os.Exit(1)
+}
+
+// errorTok reports an error at the location of tok and exits.
+func errorTok(tok *token, format string, a ...interface{}) {
+	verrorAt(tok.file, tok.offset, tok.displayName(), tok.lineDelta, fmt.Sprintf(format, a...))
+	os.Exit(1)
+}
+
+// warnTok reports a warning at the location of tok.
+func warnTok(tok *token, format string, a ...interface{}) {
+	verrorAt(tok.file, tok.offset, tok.displayName(), tok.lineDelta, "warning: "+fmt.Sprintf(format, a...))
+}
+
 // foo.c:10:5: x + y = 1;
 //               ^ error message here
-func errorAt(msg string) {
+//
+// The location is reported with name and the line number adjusted by lineDelta.
+func verrorAt(f *file, pos int, name string, lineDelta int, msg string) {
 
-	pos := len(userIn) - len(in)
+	userIn := f.source
+	pos = f.sourcePos(pos)
 
 	start := pos
-	for i := pos; i >= 0 && userIn[i] != '\n'; i-- {
-		start = i
+	for start > 0 && userIn[start-1] != '\n' {
+		start--
 	}
 
 	end := pos
-	for i := 0; i < len(in) && in[i] != '\n'; i++ {
+	for end < len(userIn) && userIn[end] != '\n' {
 		end++
 	}
+	line := userIn[start:end]
+
+	lineNo := 1 + strings.Count(userIn[:pos], "\n") + lineDelta
+
+	pos = pos - start
+	indent, _ := fmt.Fprintf(os.Stderr, "%s:%d:%d: ", name, lineNo, pos+1)
+	_, _ = fmt.Fprintf(os.Stderr, "%s\n", line)
 
-	lineNo := 1
-	for _, c := range userIn[:pos] {
-		if c == '\n' {
-			lineNo++
+	// Keep tabs so that the caret lines up with the source line.
+	margin := []byte(strings.Repeat(" ", indent+pos))
+	for i := 0; i < pos && i < len(line); i++ {
+		if line[i] == '\t' {
+			margin[indent+i] = '\t'
 		}
 	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s^\n", margin)
+	_, _ = fmt.Fprintf(os.Stderr, "%s%s\n", margin, msg)
+}
 
-	pos = pos - start
-	indent, _ := fmt.Fprintf(os.Stderr, "%s:%d:%d: ", currentFilename, lineNo, pos+1)
-	_, _ = fmt.Fprintf(os.Stderr, "%s\n", userIn[start:end])
-	_, _ = fmt.Fprintln(os.Stderr, strings.Repeat(" ", indent+pos-1), "^")
-	_, _ = fmt.Fprintln(os.Stderr, strings.Repeat(" ", indent+pos-1), msg)
-	os.Exit(1)
+// strChar is a character of a string literal. It is either a code point,
+// which is encoded for the element type, or a code unit given by an octal
+// or hex escape, which is stored as is.
+type strChar struct {
+	c    int
+	unit bool
 }
 
-func toString() *token {
-	start := len(userIn) - len(in)
-	in = in[1:]
-	for len(in) > 0 && in[0] != '"' {
-		in = in[1:]
+// stringPrefixLen returns the length of the encoding prefix if a string
+// literal starts at the current position, or -1.
+func stringPrefixLen() int {
+	for _, p := range []string{"", "u8", "u", "U", "L"} {
+		if strings.HasPrefix(in, p+"\"") {
+			return len(p)
+		}
 	}
-	end := len(userIn) - len(in)
-	str := userIn[start+1 : end]
-	str += "\000"
+	return -1
+}
+
+// stringPrefix returns the encoding prefix of a string literal token.
+func stringPrefix(tok *token) string {
+	return tok.val[:strings.IndexByte(tok.val, '"')]
+}
 
-	in = in[1:]
+// toString reads a string literal whose encoding prefix is p bytes long.
+func toString(p int) *token {
+	lexErr = ""
+	elem := stringElemType(in[:p])
+	max := 1<<(8*elem.size) - 1
 
-	return &token{kind: tokenKindStringLiteral, str: str}
+	var chars []strChar
+	n := p + 1
+	for n < len(in) && in[n] != '"' && in[n] != '\n' {
+		if in[n] != '\\' {
+			r, l := utf8.DecodeRuneInString(in[n:])
+			if r == utf8.RuneError && l == 1 {
+				// Not UTF-8; the byte is kept as is.
+				chars = append(chars, strChar{c: int(in[n]), unit: true})
+			} else {
+				chars = append(chars, strChar{c: int(r)})
+			}
+			n += l
+			continue
+		}
+		c, l, ucn := readEscapedChar(n+1, max)
+		chars = append(chars, strChar{c: c, unit: !ucn})
+		n += 1 + l
+	}
+	if n == len(in) || in[n] != '"' {
+		tok := newToken(tokenKindStringLiteral, n)
+		tok.err = "unclosed string literal"
+		return tok
+	}
+	tok := newToken(tokenKindStringLiteral, n+1)
+	tok.chars = chars
+	tok.err = lexErr
+	return tok
 }
 
-func toInt() int {
-	var ret int
-	for len(in) > 0 && in[0] >= '0' && in[0] <= '9' {
-		ret = ret*10 + int(in[0]-'0')
-		in = in[1:]
+// encodeString returns the contents of a string literal with elements of type
+// elem, including the terminating null character, as little-endian bytes.
+func encodeString(chars []strChar, elem *typ) []byte {
+	var units []int
+	for _, ch := range chars {
+		switch {
+		case ch.unit:
+			units = append(units, ch.c)
+		case elem.size == 1:
+			var b [utf8.UTFMax]byte
+			for _, c := range b[:utf8.EncodeRune(b[:], rune(ch.c))] {
+				units = append(units, int(c))
+			}
+		case elem.size == 2:
+			for _, c := range utf16.Encode([]rune{rune(ch.c)}) {
+				units = append(units, int(c))
+			}
+		default:
+			units = append(units, ch.c)
+		}
 	}
-	return ret
+	units = append(units, 0)
+
+	buf := make([]byte, 0, len(units)*elem.size)
+	for _, u := range units {
+		for i := 0; i < elem.size; i++ {
+			buf = append(buf, byte(u>>(8*i)))
+		}
+	}
+	return buf
+}
+
+// toChar reads a character constant, which has type int.
+func toChar() *token {
+	lexErr = ""
+	if len(in) < 2 || in[1] == '\n' || in[1] == '\'' {
+		return badChar("empty or unclosed character constant")
+	}
+
+	var c int
+	var n int
+	if in[1] == '\\' {
+		v, l, ucn := readEscapedChar(2, 0xff)
+		c, n = v, 2+l
+		if !ucn {
+			c = int(int8(v))
+		}
+	} else {
+		r, l := utf8.DecodeRuneInString(in[1:])
+		c, n = int(r), 1+l
+		if l == 1 {
+			c = int(int8(in[1]))
+		}
+	}
+
+	if n >= len(in) || in[n] != '\'' {
+		return badChar("unclosed character constant")
+	}
+	tok := newToken(tokenKindNumberLiteral, n+1)
+	tok.num = c
+	tok.ty = newLiteralType("int")
+	tok.err = lexErr
+	return tok
+}
+
+// badChar reads an invalid character constant up to the closing quote or the end of the line.
+func badChar(msg string) *token {
+	n := 1
+	for n < len(in) && in[n] != '\'' && in[n] != '\n' {
+		n++
+	}
+	if n < len(in) && in[n] == '\'' {
+		n++
+	}
+	tok := newToken(tokenKindNumberLiteral, n)
+	tok.ty = newLiteralType("int")
+	tok.err = msg
+	return tok
+}
+
+// readEscapedChar decodes the escape sequence that starts at in[pos], just after
+// the backslash. It returns the value, the number of bytes read, and whether it
+// was a universal character name (\u or \U), which denotes a code point rather
+// than a code unit. Octal and hex escapes must not exceed max.
+func readEscapedChar(pos int, max int) (int, int, bool) {
+	if pos >= len(in) {
+		lexError("unterminated escape sequence")
+		return 0, 0, false
+	}
+
+	c := in[pos]
+	switch {
+	case c >= '0' && c <= '7':
+		// Octal escapes have at most three digits.
+		v, n := 0, 0
+		for n < 3 && pos+n < len(in) && in[pos+n] >= '0' && in[pos+n] <= '7' {
+			v = v*8 + int(in[pos+n]-'0')
+			n++
+		}
+		if v > max {
+			lexError("octal escape sequence out of range")
+			v &= max
+		}
+		return v, n, false
+	case c == 'x':
+		v, n := 0, 1
+		for pos+n < len(in) && isHexDigit(in[pos+n]) {
+			v = v*16 + hexValue(in[pos+n])
+			if v > max {
+				lexError("hex escape sequence out of range")
+				v &= max
+			}
+			n++
+		}
+		if n == 1 {
+			lexError("invalid hex escape sequence")
+		}
+		return v, n, false
+	case c == 'u' || c == 'U':
+		digits := 4
+		if c == 'U' {
+			digits = 8
+		}
+		v := 0
+		for i := 1; i <= digits; i++ {
+			if pos+i >= len(in) || !isHexDigit(in[pos+i]) {
+				lexError("incomplete universal character name")
+				return 0, i, true
+			}
+			v = v*16 + hexValue(in[pos+i])
+		}
+		if v > unicode.MaxRune || (v >= 0xd800 && v <= 0xdfff) {
+			lexError("invalid universal character name")
+		}
+		return v, 1 + digits, true
+	}
+
+	switch c {
+	case 'a':
+		return '\a', 1, false
+	case 'b':
+		return '\b', 1, false
+	case 't':
+		return '\t', 1, false
+	case 'n':
+		return '\n', 1, false
+	case 'v':
+		return '\v', 1, false
+	case 'f':
+		return '\f', 1, false
+	case 'r':
+		return '\r', 1, false
+	case 'e':
+		// [GNU] \e is the ASCII escape character.
+		return 27, 1, false
+	}
+	return int(c), 1, false
 }
 
-func isAlpha() bool {
-	return (in[0] >= 'a' && in[0] <= 'z') || (in[0] >= 'A' && in[0] <= 'Z') || in[0] == '_'
+// toNumber reads a preprocessing number, which is converted to an integer or
+// floating constant by convertNumber after preprocessing.
+func toNumber() *token {
+	n := 1
+	for n < len(in) {
+		if strings.ContainsRune("eEpP", rune(in[n-1])) && (in[n] == '+' || in[n] == '-') {
+			n++
+		} else if isAlpha(in[n]) || isDigit(in[n]) || in[n] == '.' {
+			n++
+		} else {
+			break
+		}
+	}
+	return newToken(tokenKindNumberLiteral, n)
+}
+
+// convertNumber sets the value and the type of a number literal read by toNumber.
+func convertNumber(tok *token) {
+	if !convertIntLiteral(tok) {
+		convertFloatLiteral(tok)
+	}
+}
+
+// intSuffixes maps every valid integer suffix to whether it is unsigned and whether it is long.
+var intSuffixes = map[string][2]bool{}
+
+func init() {
+	for _, u := range []string{"", "u", "U"} {
+		for _, l := range []string{"", "l", "L", "ll", "LL"} {
+			intSuffixes[u+l] = [2]bool{u != "", l != ""}
+			intSuffixes[l+u] = [2]bool{u != "", l != ""}
+		}
+	}
 }
 
-func isDigit() bool {
-	return in[0] >= '0' && in[0] <= '9'
+// convertIntLiteral sets the value and the type of an integer constant as
+// C11 6.4.4.1 describes: the first type in the list for its base and suffix
+// that can represent the value. It returns false if tok is not an integer constant.
+func convertIntLiteral(tok *token) bool {
+	s := tok.val
+	base := 10
+	switch {
+	case len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X") && isHexDigit(s[2]):
+		base, s = 16, s[2:]
+	case len(s) > 2 && (s[:2] == "0b" || s[:2] == "0B") && (s[2] == '0' || s[2] == '1'):
+		base, s = 2, s[2:]
+	case len(s) > 1 && s[0] == '0':
+		base = 8
+	}
+
+	i := 0
+	for i < len(s) && isHexDigit(s[i]) && hexValue(s[i]) < base {
+		i++
+	}
+
+	if strings.ContainsAny(tok.val, ".pP") || (base != 16 && strings.ContainsAny(tok.val, "eE")) {
+		return false
+	}
+
+	suffix, ok := intSuffixes[s[i:]]
+	if !ok {
+		errorTok(tok, "invalid integer constant")
+	}
+	isUnsigned, isLong := suffix[0], suffix[1]
+
+	v, err := strconv.ParseUint(s[:i], base, 64)
+	if err != nil {
+		errorTok(tok, "integer constant is too large")
+	}
+
+	var ty string
+	switch {
+	case isUnsigned && isLong:
+		ty = "unsigned long"
+	case isLong:
+		ty = "long"
+		if v>>63 > 0 {
+			ty = "unsigned long"
+		}
+	case isUnsigned:
+		ty = "unsigned int"
+		if v>>32 > 0 {
+			ty = "unsigned long"
+		}
+	case base == 10:
+		ty = "int"
+		if v>>63 > 0 {
+			ty = "unsigned long"
+		} else if v>>31 > 0 {
+			ty = "long"
+		}
+	default:
+		ty = "int"
+		if v>>63 > 0 {
+			ty = "unsigned long"
+		} else if v>>32 > 0 {
+			ty = "long"
+		} else if v>>31 > 0 {
+			ty = "unsigned int"
+		}
+	}
+
+	tok.num = int(v)
+	tok.ty = newLiteralType(ty)
+	return true
+}
+
+func convertFloatLiteral(tok *token) {
+	s := tok.val
+	ty := "double"
+	switch s[len(s)-1] {
+	case 'f', 'F':
+		s, ty = s[:len(s)-1], "float"
+	case 'l', 'L':
+		// long double is not supported and is treated as double.
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) || strings.Contains(s, "_") {
+		errorTok(tok, "invalid floating constant")
+	}
+
+	tok.fval = v
+	tok.ty = newLiteralType(ty)
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+// isIdentStart reports whether c can start an identifier.
+// '$' is accepted only with -fdollars-in-identifiers, as a GNU extension.
+func isIdentStart(c byte) bool {
+	return isAlpha(c) || (c == '$' && optDollarsInIdent)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// convertTokens turns preprocessing tokens into the tokens the parser expects.
+func convertTokens(toks []*token) {
+	for _, tok := range toks {
+		if tok.err != "" {
+			errorTok(tok, "%s", tok.err)
+		}
+		switch tok.kind {
+		case tokenKindIdent:
+			identifierToken(tok)
+		case tokenKindNumberLiteral:
+			// Character constants already have their value.
+			if tok.ty == nil {
+				convertNumber(tok)
+			}
+		}
+	}
 }
 
-func identifierToken(val string) *token {
+func identifierToken(tok *token) *token {
 	for _, w := range []string{"return", "if", "else", "while", "for", "sizeof"} {
-		if val == w {
-			return &token{kind: tokenKindReserved, val: val}
+		if tok.val == w {
+			tok.kind = tokenKindReserved
+			return tok
 		}
 	}
-	for _, w := range []string{"int", "char", "struct"} {
-		if val == w {
-			return &token{kind: tokenKindType, val: val}
+	for _, w := range []string{"int", "char", "short", "long", "signed", "unsigned", "void", "_Bool", "struct", "union", "enum", "float", "double", "typedef"} {
+		if tok.val == w {
+			tok.kind = tokenKindType
+			return tok
 		}
 	}
-	return &token{kind: tokenKindIdent, val: val}
+	return tok
 }
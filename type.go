@@ -1,5 +1,7 @@
 package main
 
+import "strings"
+
 type typeKind int
 
 const (
@@ -9,27 +11,58 @@ const (
 	typeKindArray
 	typeKindStruct
 	typeKindPtr
+	typeKindLong
+	typeKindFloat
+	typeKindDouble
+	typeKindFunc
+	typeKindShort
+	typeKindVoid
+	typeKindEnum
+	typeKindUnion
 )
 
 type typ struct {
 	kind  typeKind
 	base  *typ
 	name  string
+	tok   *token
 	size  int
 	align int
 
+	isUnsigned bool
+
+	// The type a named copy was made from. A declarator copies its base
+	// type to name it, and a struct completed later is found through this.
+	origin *typ
+
 	// func
-	params []*typ
+	returnTy   *typ
+	params     []*typ
+	isVariadic bool
+	hasProto   bool // false if declared with "()", which leaves the parameters unspecified
 
 	// array
 	length int
 
-	// struct
-	members []*member
+	// struct, union
+	members      []*member
+	isIncomplete bool // declared by its tag, with no members yet
 }
 
 func (ty *typ) isInteger() bool {
-	return ty.kind == typeKindInt || ty.kind == typeKindChar
+	switch ty.kind {
+	case typeKindBool, typeKindChar, typeKindShort, typeKindInt, typeKindLong, typeKindEnum:
+		return true
+	}
+	return false
+}
+
+func (ty *typ) isFlonum() bool {
+	return ty.kind == typeKindFloat || ty.kind == typeKindDouble
+}
+
+func (ty *typ) isNumeric() bool {
+	return ty.isInteger() || ty.isFlonum()
 }
 
 func (ty *typ) hasBase() bool {
@@ -42,21 +75,55 @@ func newType(kind typeKind, size, align int) *typ {
 
 func newLiteralType(s string) *typ {
 	typeKindMap := map[string]typeKind{
-		"int":  typeKindInt,
-		"bool": typeKindBool,
-		"char": typeKindChar,
+		"int":    typeKindInt,
+		"bool":   typeKindBool,
+		"char":   typeKindChar,
+		"short":  typeKindShort,
+		"long":   typeKindLong,
+		"float":  typeKindFloat,
+		"double": typeKindDouble,
+		"void":   typeKindVoid,
 	}
 	typeKindSize := map[string]int{
-		"int":  8,
-		"bool": 1,
-		"char": 1,
+		"int":    4,
+		"bool":   1,
+		"char":   1,
+		"short":  2,
+		"long":   8,
+		"float":  4,
+		"double": 8,
+		"void":   1,
 	}
 	typeKindAlign := map[string]int{
-		"int":  8,
-		"bool": 1,
-		"char": 1,
+		"int":    4,
+		"bool":   1,
+		"char":   1,
+		"short":  2,
+		"long":   8,
+		"float":  4,
+		"double": 8,
+		"void":   1,
 	}
-	return newType(typeKindMap[s], typeKindSize[s], typeKindAlign[s])
+	isUnsigned := strings.HasPrefix(s, "unsigned ")
+	s = strings.TrimPrefix(s, "unsigned ")
+	ty := newType(typeKindMap[s], typeKindSize[s], typeKindAlign[s])
+	ty.isUnsigned = isUnsigned
+	return ty
+}
+
+// stringElemType returns the element type of a string literal with the
+// encoding prefix p: wchar_t is int, char16_t is unsigned short and char32_t
+// is unsigned int.
+func stringElemType(p string) *typ {
+	switch p {
+	case "L":
+		return newLiteralType("int")
+	case "u":
+		return newLiteralType("unsigned short")
+	case "U":
+		return newLiteralType("unsigned int")
+	}
+	return newLiteralType("char")
 }
 
 type member struct {
@@ -72,7 +139,7 @@ func newStructType(members []*member) *typ {
 	for i := range members {
 		m := members[i]
 		m.offset = alignTo(offset, m.ty.align)
-		offset += m.ty.size
+		offset = m.offset + m.ty.size
 
 		if align < m.ty.align {
 			align = m.ty.align
@@ -84,6 +151,40 @@ func newStructType(members []*member) *typ {
 	return ty
 }
 
+// newIncompleteType returns a struct or union type declared by its tag
+// only, which is completed when its members are defined.
+func newIncompleteType(kind typeKind, tag string) *typ {
+	ty := newType(kind, 0, 1)
+	ty.name = tag
+	ty.isIncomplete = true
+	return ty
+}
+
+func newUnionType(members []*member) *typ {
+
+	align := 1
+	size := 0
+	for i := range members {
+		m := members[i]
+		m.offset = 0
+		if size < m.ty.size {
+			size = m.ty.size
+		}
+		if align < m.ty.align {
+			align = m.ty.align
+		}
+	}
+
+	ty := newType(typeKindUnion, alignTo(size, align), align)
+	ty.members = members
+	return ty
+}
+
+// enumType returns a new enum type, which is compatible with int.
+func enumType() *typ {
+	return newType(typeKindEnum, 4, 4)
+}
+
 func pointerTo(base *typ) *typ {
 	ty := newType(typeKindPtr, 8, 8)
 	ty.base = base
@@ -91,11 +192,38 @@ func pointerTo(base *typ) *typ {
 	return ty
 }
 
+func funcType(returnTy *typ, params []*typ, isVariadic bool) *typ {
+	ty := newType(typeKindFunc, 1, 1)
+	ty.returnTy = returnTy
+	ty.params = params
+	ty.isVariadic = isVariadic
+	ty.name = returnTy.name
+	ty.tok = returnTy.tok
+	return ty
+}
+
+// copyType returns a copy of ty to be given the name of a declarator.
+func copyType(ty *typ) *typ {
+	ret := new(typ)
+	*ret = *ty
+	if ret.origin == nil {
+		ret.origin = ty
+	}
+	return ret
+}
+
+// arrayOf returns the type of an array of base. A length of -1 means that it
+// is unknown, as in "int a[]"; such an array has size 0.
 func arrayOf(base *typ, length int) *typ {
-	ty := newType(typeKindArray, base.size*length, base.align)
+	size := 0
+	if length > 0 {
+		size = base.size * length
+	}
+	ty := newType(typeKindArray, size, base.align)
 	ty.base = base
 	ty.length = length
 	ty.name = base.name
+	ty.tok = base.tok
 	return ty
 }
 
@@ -126,19 +254,21 @@ func addType(n interface{}) {
 	case *intLit:
 		n.setType(newLiteralType("int"))
 		return
+	case *floatLit:
+		n.setType(newLiteralType("double"))
+		return
 	case *addrNode:
+		// "&" applies to an array itself, so &a for int a[3] has type int (*)[3].
 		addType(n.child)
-		ct := n.child.getType()
-		if ct.kind == typeKindArray {
-			ct = n.child.getType().base
-		}
-		ty := pointerTo(ct)
-		n.setType(ty)
+		n.setType(pointerTo(n.child.getType()))
 		return
 	case *derefNode:
 		addType(n.child)
 		ty := n.child.getType()
 		if ty.hasBase() {
+			if ty.base.kind == typeKindVoid {
+				errorTok(n.tok, "dereferencing a pointer to void")
+			}
 			n.setType(ty.base)
 			return
 		}
@@ -149,9 +279,30 @@ func addType(n interface{}) {
 		addType(n.rhs)
 		switch n.op {
 		case "+", "-", "*", "/":
+			if n.lhs.getType().isNumeric() && n.rhs.getType().isNumeric() {
+				usualArithConv(n)
+			}
+			n.setType(n.lhs.getType())
+		case "%":
+			if !n.lhs.getType().isInteger() || !n.rhs.getType().isInteger() {
+				errorTok(n.tok, "invalid operands")
+			}
+			usualArithConv(n)
+			n.setType(n.lhs.getType())
+		case "<<", ">>":
+			// The operands are promoted separately; the result has the type of lhs.
+			if !n.lhs.getType().isInteger() || !n.rhs.getType().isInteger() {
+				errorTok(n.tok, "invalid operands")
+			}
+			n.lhs = newCast(n.lhs, promote(n.lhs.getType()))
+			n.rhs = newCast(n.rhs, promote(n.rhs.getType()))
 			n.setType(n.lhs.getType())
 		case "==", "!=", "<", "<=":
-			n.setType(newLiteralType("bool"))
+			if n.lhs.getType().isNumeric() && n.rhs.getType().isNumeric() {
+				usualArithConv(n)
+			}
+			// The result is 0 or 1 of type int.
+			n.setType(newLiteralType("int"))
 		}
 		return
 	case *obj:
@@ -189,7 +340,51 @@ func addType(n interface{}) {
 	case *assignNode:
 		addType(n.lhs)
 		addType(n.rhs)
-		n.setType(n.lhs.getType())
+		ty := n.lhs.getType()
+		if ty.kind != typeKindArray && ty.kind != typeKindStruct && ty.kind != typeKindUnion {
+			n.rhs = newCast(n.rhs, ty)
+		}
+		n.setType(ty)
 		return
 	}
 }
+
+// commonType returns the type that both operands of an arithmetic operator
+// are converted to, following the usual arithmetic conversions.
+func commonType(ty1, ty2 *typ) *typ {
+	if ty1.kind == typeKindDouble || ty2.kind == typeKindDouble {
+		return newLiteralType("double")
+	}
+	if ty1.kind == typeKindFloat || ty2.kind == typeKindFloat {
+		return newLiteralType("float")
+	}
+
+	ty1, ty2 = promote(ty1), promote(ty2)
+	if ty1.size != ty2.size {
+		if ty1.size < ty2.size {
+			return ty2
+		}
+		return ty1
+	}
+
+	// Of two types of the same size, the unsigned one wins.
+	if ty2.isUnsigned {
+		return ty2
+	}
+	return ty1
+}
+
+// promote applies the integer promotions: types smaller than int become int,
+// which can represent all their values.
+func promote(ty *typ) *typ {
+	if ty.isInteger() && ty.size < 4 {
+		return newLiteralType("int")
+	}
+	return ty
+}
+
+func usualArithConv(n *binaryNode) {
+	ty := commonType(n.lhs.getType(), n.rhs.getType())
+	n.lhs = newCast(n.lhs, ty)
+	n.rhs = newCast(n.rhs, ty)
+}